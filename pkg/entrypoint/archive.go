@@ -0,0 +1,248 @@
+package entrypoint
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/wrouesnel/p2cli/pkg/fileconsts"
+	"github.com/wrouesnel/p2cli/pkg/templating"
+)
+
+const (
+	// DefaultArchiveFileMode is the mode of files in archive outputs unless changed with SetMode.
+	DefaultArchiveFileMode = fileconsts.OS_USER_RW | fileconsts.OS_GROUP_R | fileconsts.OS_OTH_R
+	// DefaultArchiveDirMode is the mode of directories in archive outputs.
+	DefaultArchiveDirMode = fileconsts.OS_USER_RWX | fileconsts.OS_GROUP_R | fileconsts.OS_GROUP_X |
+		fileconsts.OS_OTH_R | fileconsts.OS_OTH_X
+)
+
+const (
+	ArchiveFormatTar  = "tar"
+	ArchiveFormatZip  = "zip"
+	ArchiveFormatCpio = "cpio"
+	ArchiveFormatOCI  = "oci"
+)
+
+const (
+	CompressionAuto = "auto"
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// archiveEntry describes a single file or directory to be written to an archive output.
+type archiveEntry struct {
+	// Name is the slash-separated path of the entry within the archive. Directories have
+	// no trailing slash.
+	Name    string
+	Mode    int64
+	UID     int
+	GID     int
+	ModTime time.Time
+	Data    []byte
+}
+
+// archiveWriter is implemented by the archive formats p2 can emit.
+type archiveWriter interface {
+	WriteDir(entry archiveEntry) error
+	WriteFile(entry archiveEntry) error
+	Close() error
+}
+
+// tarArchiveWriter writes entries to a tar stream.
+type tarArchiveWriter struct {
+	tw *tar.Writer
+}
+
+func newTarArchiveWriter(w io.Writer) *tarArchiveWriter {
+	return &tarArchiveWriter{tw: tar.NewWriter(w)}
+}
+
+func (t *tarArchiveWriter) WriteDir(entry archiveEntry) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     entry.Name + "/",
+		Mode:     entry.Mode,
+		Uid:      entry.UID,
+		Gid:      entry.GID,
+		ModTime:  entry.ModTime,
+	}
+	if err := t.tw.WriteHeader(header); err != nil {
+		return errors.Wrap(err, "tar: write directory header failed")
+	}
+	return nil
+}
+
+func (t *tarArchiveWriter) WriteFile(entry archiveEntry) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     entry.Name,
+		Size:     int64(len(entry.Data)),
+		Mode:     entry.Mode,
+		Uid:      entry.UID,
+		Gid:      entry.GID,
+		ModTime:  entry.ModTime,
+	}
+	if err := t.tw.WriteHeader(header); err != nil {
+		return errors.Wrap(err, "tar: write header failed")
+	}
+	if _, err := t.tw.Write(entry.Data); err != nil {
+		return errors.Wrap(err, "tar: write file body failed")
+	}
+	return nil
+}
+
+func (t *tarArchiveWriter) Close() error {
+	return errors.Wrap(t.tw.Close(), "tar: close failed")
+}
+
+// compressionForName infers the compression to apply to an archive from its file name.
+func compressionForName(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".gz"), strings.HasSuffix(lower, ".tgz"):
+		return CompressionGzip
+	case strings.HasSuffix(lower, ".zst"), strings.HasSuffix(lower, ".tzst"):
+		return CompressionZstd
+	default:
+		return CompressionNone
+	}
+}
+
+// nopWriteCloser adapts an io.Writer which should not be closed by the archive.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// newCompressor wraps w with the named compression. Compressors are configured to produce
+// the same output for the same input.
+func newCompressor(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionNone, "":
+		return nopWriteCloser{w}, nil
+	case CompressionGzip:
+		gw, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+		if err != nil {
+			return nil, errors.Wrap(err, "newCompressor")
+		}
+		return gw, nil
+	case CompressionZstd:
+		zw, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, errors.Wrap(err, "newCompressor")
+		}
+		return zw, nil
+	default:
+		return nil, fmt.Errorf("newCompressor: unknown compression: %s", compression)
+	}
+}
+
+// archiveOutput implements a TemplateEngine which renders each template into a buffer and
+// writes it as an entry of an archive. Parent directories of each file are emitted as
+// directory entries the first time they are seen.
+type archiveOutput struct {
+	writer    archiveWriter
+	filterSet *templating.FilterSet
+	rootDir   string
+	prefix    string
+	modTime   time.Time
+	seenDirs  map[string]struct{}
+}
+
+func newArchiveOutput(writer archiveWriter, filterSet *templating.FilterSet, rootDir string,
+	prefix string, modTime time.Time,
+) *archiveOutput {
+	return &archiveOutput{
+		writer:    writer,
+		filterSet: filterSet,
+		rootDir:   rootDir,
+		prefix:    prefix,
+		modTime:   modTime,
+		seenDirs:  make(map[string]struct{}),
+	}
+}
+
+// entryName determines the archive path of an output file.
+func (a *archiveOutput) entryName(outputPath string) (string, error) {
+	relPath, err := filepath.Rel(a.rootDir, outputPath)
+	if err != nil {
+		return "", fmt.Errorf("could not determine relative output path: %w", err)
+	}
+	name := filepath.ToSlash(filepath.Join(a.prefix, relPath))
+	// Archive members should never be absolute.
+	return strings.TrimLeft(name, "/"), nil
+}
+
+func (a *archiveOutput) writeParentDirs(name string) error {
+	dir := path.Dir(name)
+	if dir == "." || dir == "/" {
+		return nil
+	}
+	if _, found := a.seenDirs[dir]; found {
+		return nil
+	}
+	if err := a.writeParentDirs(dir); err != nil {
+		return err
+	}
+	a.seenDirs[dir] = struct{}{}
+	return a.writer.WriteDir(archiveEntry{
+		Name:    dir,
+		Mode:    DefaultArchiveDirMode,
+		ModTime: a.modTime,
+	})
+}
+
+// PrepareOutput implements the TemplateEngine PrepareOutput function.
+func (a *archiveOutput) PrepareOutput(inputData pongo2.Context, outputPath string) (io.Writer, func() error, error) {
+	name, err := a.entryName(outputPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entry := archiveEntry{
+		Name:    name,
+		Mode:    DefaultArchiveFileMode,
+		ModTime: a.modTime,
+	}
+
+	// Modify filterSet so we receive the Chown/Chmod operations
+	a.filterSet.Chown = func(name string, uid, gid int) error {
+		if uid != -1 {
+			entry.UID = uid
+		}
+		if gid != -1 {
+			entry.GID = gid
+		}
+		return nil
+	}
+	a.filterSet.Chmod = func(name string, mode os.FileMode) error {
+		entry.Mode = int64(mode)
+		return nil
+	}
+
+	// Setup a buffer for the output
+	buf := new(bytes.Buffer)
+
+	finalizer := func() error {
+		if err := a.writeParentDirs(entry.Name); err != nil {
+			return err
+		}
+		entry.Data = buf.Bytes()
+		return a.writer.WriteFile(entry)
+	}
+
+	return buf, finalizer, nil
+}
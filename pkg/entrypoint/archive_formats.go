@@ -0,0 +1,140 @@
+package entrypoint
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"io"
+	"os"
+	"time"
+
+	"github.com/cavaliergopher/cpio"
+	"github.com/pkg/errors"
+)
+
+// zipExtraUnixID is the Info-ZIP "new Unix" extra field which carries file ownership.
+const zipExtraUnixID = 0x7875
+
+// zipEpoch is the earliest time representable in a zip file.
+//
+//nolint:gochecknoglobals
+var zipEpoch = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// zipArchiveWriter writes entries to a zip file.
+type zipArchiveWriter struct {
+	zw *zip.Writer
+}
+
+func newZipArchiveWriter(w io.Writer) *zipArchiveWriter {
+	return &zipArchiveWriter{zw: zip.NewWriter(w)}
+}
+
+// zipOwnerExtra encodes the uid and gid of an entry as an Info-ZIP Unix extra field.
+//
+//nolint:mnd
+func zipOwnerExtra(uid int, gid int) []byte {
+	extra := make([]byte, 0, 15)
+	extra = binary.LittleEndian.AppendUint16(extra, zipExtraUnixID)
+	extra = binary.LittleEndian.AppendUint16(extra, 11)
+	extra = append(extra, 1, 4)
+	extra = binary.LittleEndian.AppendUint32(extra, uint32(uid)) //nolint:gosec
+	extra = append(extra, 4)
+	extra = binary.LittleEndian.AppendUint32(extra, uint32(gid)) //nolint:gosec
+	return extra
+}
+
+func (z *zipArchiveWriter) header(entry archiveEntry, mode os.FileMode) *zip.FileHeader {
+	modTime := entry.ModTime
+	if modTime.Before(zipEpoch) {
+		modTime = zipEpoch
+	}
+
+	header := &zip.FileHeader{
+		Name:     entry.Name,
+		Method:   zip.Deflate,
+		Modified: modTime,
+		Extra:    zipOwnerExtra(entry.UID, entry.GID),
+	}
+	header.SetMode(mode)
+	return header
+}
+
+func (z *zipArchiveWriter) WriteDir(entry archiveEntry) error {
+	header := z.header(entry, os.ModeDir|os.FileMode(entry.Mode)) //nolint:gosec
+	header.Name += "/"
+	header.Method = zip.Store
+	if _, err := z.zw.CreateHeader(header); err != nil {
+		return errors.Wrap(err, "zip: write directory header failed")
+	}
+	return nil
+}
+
+func (z *zipArchiveWriter) WriteFile(entry archiveEntry) error {
+	w, err := z.zw.CreateHeader(z.header(entry, os.FileMode(entry.Mode))) //nolint:gosec
+	if err != nil {
+		return errors.Wrap(err, "zip: write header failed")
+	}
+	if _, err := w.Write(entry.Data); err != nil {
+		return errors.Wrap(err, "zip: write file body failed")
+	}
+	return nil
+}
+
+func (z *zipArchiveWriter) Close() error {
+	return errors.Wrap(z.zw.Close(), "zip: close failed")
+}
+
+// cpioArchiveWriter writes entries to a cpio stream in the SVR4 "newc" format.
+type cpioArchiveWriter struct {
+	cw    *cpio.Writer
+	inode int64
+}
+
+func newCpioArchiveWriter(w io.Writer) *cpioArchiveWriter {
+	return &cpioArchiveWriter{cw: cpio.NewWriter(w)}
+}
+
+// nextInode allocates inode numbers so that no two entries appear to be hard links.
+func (c *cpioArchiveWriter) nextInode() int64 {
+	c.inode++
+	return c.inode
+}
+
+func (c *cpioArchiveWriter) WriteDir(entry archiveEntry) error {
+	header := &cpio.Header{
+		Name:    entry.Name,
+		Links:   2, //nolint:mnd
+		Mode:    cpio.TypeDir | cpio.FileMode(entry.Mode&cpio.ModePerm),
+		Uid:     entry.UID,
+		Guid:    entry.GID,
+		ModTime: entry.ModTime,
+		Inode:   c.nextInode(),
+	}
+	if err := c.cw.WriteHeader(header); err != nil {
+		return errors.Wrap(err, "cpio: write directory header failed")
+	}
+	return nil
+}
+
+func (c *cpioArchiveWriter) WriteFile(entry archiveEntry) error {
+	header := &cpio.Header{
+		Name:    entry.Name,
+		Links:   1,
+		Size:    int64(len(entry.Data)),
+		Mode:    cpio.TypeReg | cpio.FileMode(entry.Mode&(cpio.ModePerm|cpio.ModeSetuid|cpio.ModeSetgid|cpio.ModeSticky)),
+		Uid:     entry.UID,
+		Guid:    entry.GID,
+		ModTime: entry.ModTime,
+		Inode:   c.nextInode(),
+	}
+	if err := c.cw.WriteHeader(header); err != nil {
+		return errors.Wrap(err, "cpio: write header failed")
+	}
+	if _, err := c.cw.Write(entry.Data); err != nil {
+		return errors.Wrap(err, "cpio: write file body failed")
+	}
+	return nil
+}
+
+func (c *cpioArchiveWriter) Close() error {
+	return errors.Wrap(c.cw.Close(), "cpio: close failed")
+}
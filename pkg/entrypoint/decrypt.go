@@ -0,0 +1,475 @@
+package entrypoint
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variables naming age identities, shared with sops so existing key setups work
+// unchanged.
+const (
+	EnvSopsAgeKey     = "SOPS_AGE_KEY"
+	EnvSopsAgeKeyFile = "SOPS_AGE_KEY_FILE"
+)
+
+const (
+	ageBinaryHeader      = "age-encryption.org/v1\n"
+	sopsMetadataKey      = "sops"
+	sopsEnvPrefix        = "sops_"
+	sopsAgeKeyConfigPath = "sops/age/keys.txt"
+	sopsDataKeySize      = 32
+)
+
+// sopsMACOnlyEncryptedInit seeds the MAC of files written with mac_only_encrypted, so the MAC
+// differs from one over all values.
+//
+//nolint:gochecknoglobals
+var sopsMACOnlyEncryptedInit = []byte{
+	0x8a, 0x3f, 0xd2, 0xad, 0x54, 0xce, 0x66, 0x52, 0x7b, 0x10, 0x34, 0xf3, 0xd1, 0x47, 0xbe, 0x0b,
+	0x0b, 0x97, 0x5b, 0x3b, 0xf4, 0x4f, 0x72, 0xc6, 0xfd, 0xad, 0xec, 0x81, 0x76, 0xf2, 0x7d, 0x69,
+}
+
+var (
+	reSopsValue     = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.+),iv:(.+),tag:(.+),type:(.+)\]`)
+	reSopsEnvAgeKey = regexp.MustCompile(`^(?:key_groups__list_\d+__map_)?age__list_\d+__map_enc$`)
+)
+
+// sopsAgeKey is a copy of the sops data key encrypted to one age recipient.
+type sopsAgeKey struct {
+	Recipient string `yaml:"recipient"`
+	Enc       string `yaml:"enc"`
+}
+
+// sopsMetadata is the subset of the sops metadata section needed to decrypt with age.
+type sopsMetadata struct {
+	Age       []sopsAgeKey `yaml:"age"`
+	KeyGroups []struct {
+		Age []sopsAgeKey `yaml:"age"`
+	} `yaml:"key_groups"`
+	ShamirThreshold  int    `yaml:"shamir_threshold"`
+	LastModified     string `yaml:"lastmodified"`
+	MAC              string `yaml:"mac"`
+	MACOnlyEncrypted bool   `yaml:"mac_only_encrypted"`
+}
+
+// ageKeys returns every age copy of the data key.
+func (m *sopsMetadata) ageKeys() []sopsAgeKey {
+	keys := m.Age
+	for _, group := range m.KeyGroups {
+		keys = append(keys, group.Age...)
+	}
+	return keys
+}
+
+// isAgeEncrypted returns true for age files in either the binary or the armored format.
+func isAgeEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(ageBinaryHeader)) ||
+		bytes.HasPrefix(bytes.TrimSpace(data), []byte(armor.Header))
+}
+
+// decryptInput transparently decrypts age encrypted input and sops encrypted input of the
+// given format. Any other input is returned unchanged. Identities are only loaded once
+// encrypted input is found.
+func decryptInput(env map[string]string, data []byte, format SupportedType) ([]byte, error) {
+	if isAgeEncrypted(data) {
+		identities, err := loadAgeIdentities(env)
+		if err != nil {
+			return nil, err
+		}
+		return decryptAge(data, identities)
+	}
+
+	//nolint:exhaustive
+	switch format {
+	case TypeYAML, TypeJSON:
+		return decryptSopsTree(env, data, format)
+	default:
+		return data, nil
+	}
+}
+
+// loadAgeIdentities reads age identities from $SOPS_AGE_KEY and from the key file named by
+// $SOPS_AGE_KEY_FILE, or the sops default of sops/age/keys.txt in the user config directory.
+func loadAgeIdentities(env map[string]string) ([]age.Identity, error) {
+	var identities []age.Identity
+
+	if keys := env[EnvSopsAgeKey]; keys != "" {
+		parsed, err := age.ParseIdentities(strings.NewReader(keys))
+		if err != nil {
+			return nil, errors.Wrap(err, EnvSopsAgeKey)
+		}
+		identities = append(identities, parsed...)
+	}
+
+	keyFile := env[EnvSopsAgeKeyFile]
+	if keyFile == "" {
+		// As in sops, $XDG_CONFIG_HOME is honoured on every platform.
+		configDir := env["XDG_CONFIG_HOME"]
+		if configDir == "" {
+			configDir, _ = os.UserConfigDir()
+		}
+		defaultKeyFile := filepath.Join(configDir, filepath.FromSlash(sopsAgeKeyConfigPath))
+		if _, err := os.Stat(defaultKeyFile); configDir != "" && err == nil {
+			keyFile = defaultKeyFile
+		}
+	}
+	if keyFile != "" {
+		keys, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "loadAgeIdentities")
+		}
+		parsed, err := age.ParseIdentities(bytes.NewReader(keys))
+		if err != nil {
+			return nil, errors.Wrapf(err, "loadAgeIdentities: %s", keyFile)
+		}
+		identities = append(identities, parsed...)
+	}
+
+	if len(identities) == 0 {
+		return nil, errors.Errorf("input is encrypted but no age identities were found: set %s or %s",
+			EnvSopsAgeKey, EnvSopsAgeKeyFile)
+	}
+	return identities, nil
+}
+
+// decryptAge decrypts a binary or armored age file.
+func decryptAge(data []byte, identities []age.Identity) ([]byte, error) {
+	var src io.Reader = bytes.NewReader(data)
+	if !bytes.HasPrefix(data, []byte(ageBinaryHeader)) {
+		src = armor.NewReader(bytes.NewReader(bytes.TrimSpace(data)))
+	}
+	plaintext, err := age.Decrypt(src, identities...)
+	if err != nil {
+		return nil, errors.Wrap(err, "decryptAge")
+	}
+	result, err := io.ReadAll(plaintext)
+	if err != nil {
+		return nil, errors.Wrap(err, "decryptAge")
+	}
+	return result, nil
+}
+
+// sopsDecrypter decrypts the values of a sops tree with the data key while accumulating the
+// MAC over the plaintext values, in the same order sops walks them.
+type sopsDecrypter struct {
+	key              []byte
+	macOnlyEncrypted bool
+	mac              hash.Hash
+}
+
+func newSopsDecrypter(env map[string]string, metadata *sopsMetadata) (*sopsDecrypter, error) {
+	if metadata.ShamirThreshold > 1 {
+		return nil, errors.New("sops files using Shamir key groups are not supported")
+	}
+	keys := metadata.ageKeys()
+	if len(keys) == 0 {
+		return nil, errors.New("sops file has no age recipients: only age encrypted sops files are supported")
+	}
+	identities, err := loadAgeIdentities(env)
+	if err != nil {
+		return nil, err
+	}
+
+	var dataKey []byte
+	for _, key := range keys {
+		dataKey, err = decryptAge([]byte(key.Enc), identities)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "no age identity could decrypt the sops data key")
+	}
+	if len(dataKey) != sopsDataKeySize {
+		return nil, errors.New("sops data key has an invalid length")
+	}
+
+	mac := sha512.New()
+	if metadata.MACOnlyEncrypted {
+		mac.Write(sopsMACOnlyEncryptedInit)
+	}
+	return &sopsDecrypter{key: dataKey, macOnlyEncrypted: metadata.MACOnlyEncrypted, mac: mac}, nil
+}
+
+// decryptValue decrypts an ENC[...] value, returning the plaintext and its sops type.
+func (d *sopsDecrypter) decryptValue(value string, additionalData string) (string, string, error) {
+	matches := reSopsValue.FindStringSubmatch(value)
+	if matches == nil {
+		return "", "", errors.New("value is not in the sops encrypted format")
+	}
+	var parts [3][]byte
+	for idx := range parts {
+		decoded, err := base64.StdEncoding.DecodeString(matches[idx+1])
+		if err != nil {
+			return "", "", errors.Wrap(err, "decryptValue")
+		}
+		parts[idx] = decoded
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+
+	block, err := aes.NewCipher(d.key)
+	if err != nil {
+		return "", "", errors.Wrap(err, "decryptValue")
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return "", "", errors.Wrap(err, "decryptValue")
+	}
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return "", "", errors.Wrap(err, "decryptValue")
+	}
+	return string(plaintext), matches[4], nil
+}
+
+// leaf decrypts a leaf value at path if it is encrypted, and adds it to the MAC. Unencrypted
+// values are passed in their sops string form.
+func (d *sopsDecrypter) leaf(value string, path []string) (string, string, error) {
+	if !reSopsValue.MatchString(value) {
+		if !d.macOnlyEncrypted {
+			_, _ = io.WriteString(d.mac, value)
+		}
+		return value, "", nil
+	}
+	plaintext, valueType, err := d.decryptValue(value, strings.Join(path, ":")+":")
+	if err != nil {
+		return "", "", errors.Wrapf(err, "could not decrypt %s", strings.Join(path, "."))
+	}
+	if valueType != "comment" {
+		_, _ = io.WriteString(d.mac, plaintext)
+	}
+	return plaintext, valueType, nil
+}
+
+// verify checks the accumulated MAC against the encrypted MAC in the metadata.
+func (d *sopsDecrypter) verify(metadata *sopsMetadata) error {
+	lastModified, err := time.Parse(time.RFC3339, metadata.LastModified)
+	if err != nil {
+		return errors.Wrap(err, "sops metadata has an invalid lastmodified time")
+	}
+	expected, _, err := d.decryptValue(metadata.MAC, lastModified.Format(time.RFC3339))
+	if err != nil {
+		return errors.Wrap(err, "could not decrypt the sops MAC")
+	}
+	if fmt.Sprintf("%X", d.mac.Sum(nil)) != expected {
+		return errors.New("sops MAC mismatch: the file has been modified")
+	}
+	return nil
+}
+
+// sopsScalarString returns a scalar as sops hashes it: booleans are title case and floats use
+// the shortest representation.
+func sopsScalarString(node *yaml.Node) (string, bool) {
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return node.Value, true
+	}
+	switch value := value.(type) {
+	case nil:
+		return "", false
+	case bool:
+		if value {
+			return "True", true
+		}
+		return "False", true
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	case time.Time:
+		return value.Format(time.RFC3339Nano), true
+	default:
+		return fmt.Sprint(value), true
+	}
+}
+
+// walk decrypts the scalar values of a node tree in place. Sequence items share the path of
+// their parent, as in sops. Comments are dropped since sops encrypts them too.
+func (d *sopsDecrypter) walk(node *yaml.Node, path []string) error {
+	node.HeadComment, node.LineComment, node.FootComment = "", "", ""
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			if err := d.walk(child, path); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			node.Content[idx].HeadComment, node.Content[idx].LineComment = "", ""
+			if err := d.walk(node.Content[idx+1], append(path, node.Content[idx].Value)); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if node.Tag != "!!str" {
+			if value, ok := sopsScalarString(node); ok && !d.macOnlyEncrypted {
+				_, _ = io.WriteString(d.mac, value)
+			}
+			return nil
+		}
+		plaintext, valueType, err := d.leaf(node.Value, path)
+		if err != nil {
+			return err
+		}
+		if valueType == "" {
+			return nil
+		}
+		node.Style = 0
+		node.Value = plaintext
+		switch valueType {
+		case "int":
+			node.Tag = "!!int"
+		case "float":
+			node.Tag = "!!float"
+		case "bool":
+			node.Tag = "!!bool"
+			node.Value = strings.ToLower(plaintext)
+		case "time":
+			node.Tag = "!!timestamp"
+		default:
+			node.Tag = "!!str"
+		}
+	case yaml.AliasNode:
+	}
+	return nil
+}
+
+// decryptSopsTree decrypts a sops encrypted YAML or JSON document, returning it in the same
+// format without the sops metadata. Documents without sops metadata are returned unchanged.
+func decryptSopsTree(env map[string]string, data []byte, format SupportedType) ([]byte, error) {
+	if !bytes.Contains(data, []byte(sopsMetadataKey)) {
+		return data, nil
+	}
+	// JSON is parsed as YAML to walk values in document order, which the MAC depends on.
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 {
+		//nolint:nilerr
+		return data, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return data, nil
+	}
+
+	var metadataNode *yaml.Node
+	for idx := 0; idx+1 < len(root.Content); idx += 2 {
+		if root.Content[idx].Value == sopsMetadataKey {
+			metadataNode = root.Content[idx+1]
+			root.Content = append(root.Content[:idx], root.Content[idx+2:]...)
+			break
+		}
+	}
+	if metadataNode == nil {
+		return data, nil
+	}
+	metadata := new(sopsMetadata)
+	if err := metadataNode.Decode(metadata); err != nil {
+		return nil, errors.Wrap(err, "decryptSopsTree: invalid sops metadata")
+	}
+	if metadata.MAC == "" {
+		return data, nil
+	}
+
+	decrypter, err := newSopsDecrypter(env, metadata)
+	if err != nil {
+		return nil, err
+	}
+	if err := decrypter.walk(root, nil); err != nil {
+		return nil, err
+	}
+	if err := decrypter.verify(metadata); err != nil {
+		return nil, err
+	}
+
+	if format == TypeJSON {
+		var value interface{}
+		if err := root.Decode(&value); err != nil {
+			return nil, errors.Wrap(err, "decryptSopsTree")
+		}
+		result, err := json.Marshal(value)
+		return result, errors.Wrap(err, "decryptSopsTree")
+	}
+	result, err := yaml.Marshal(&doc)
+	return result, errors.Wrap(err, "decryptSopsTree")
+}
+
+// isSopsEnv returns true if dotenv input carries sops metadata.
+func isSopsEnv(data []byte) bool {
+	return bytes.Contains(data, []byte(sopsEnvPrefix+"mac="))
+}
+
+// decryptSopsEnv decrypts a sops encrypted dotenv file into its variables. The values are
+// taken as sops writes them rather than shell parsed, so they may span lines.
+func decryptSopsEnv(env map[string]string, data []byte) (map[string]string, error) {
+	// sops dotenv values are raw text with escaped newlines, and the metadata is flattened
+	// into sops_ prefixed keys.
+	type envEntry struct{ key, value string }
+	var entries []envEntry
+	metadata := new(sopsMetadata)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, errors.Errorf("decryptSopsEnv: invalid line: %s", line)
+		}
+		value = strings.ReplaceAll(value, "\\n", "\n")
+
+		metadataKey, isMetadata := strings.CutPrefix(key, sopsEnvPrefix)
+		switch {
+		case !isMetadata:
+			entries = append(entries, envEntry{key, value})
+		case reSopsEnvAgeKey.MatchString(metadataKey):
+			metadata.Age = append(metadata.Age, sopsAgeKey{Enc: value})
+		case metadataKey == "lastmodified":
+			metadata.LastModified = value
+		case metadataKey == "mac":
+			metadata.MAC = value
+		case metadataKey == "mac_only_encrypted":
+			metadata.MACOnlyEncrypted = value == "true"
+		case metadataKey == "shamir_threshold":
+			metadata.ShamirThreshold, _ = strconv.Atoi(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "decryptSopsEnv")
+	}
+
+	decrypter, err := newSopsDecrypter(env, metadata)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		value, _, err := decrypter.leaf(entry.value, []string{entry.key})
+		if err != nil {
+			return nil, err
+		}
+		result[entry.key] = value
+	}
+	if err := decrypter.verify(metadata); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
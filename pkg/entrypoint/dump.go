@@ -0,0 +1,132 @@
+package entrypoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/wrouesnel/p2cli/pkg/redact"
+	"gopkg.in/yaml.v2"
+)
+
+// Sources recorded for input keys in the --debug dump.
+const (
+	InputSourceEnv   = "env"
+	InputSourceStdin = "stdin"
+)
+
+// InputDumpEntry describes one key of the input data and where it came from.
+type InputDumpEntry struct {
+	Key     string      `json:"key"                yaml:"key"`
+	Source  string      `json:"source"             yaml:"source"`
+	RootKey string      `json:"root_key,omitempty" yaml:"root_key,omitempty"`
+	Value   interface{} `json:"value"              yaml:"value"`
+}
+
+// InputDump is the machine-readable --debug dump of the input data.
+type InputDump struct {
+	Keys []*InputDumpEntry `json:"keys" yaml:"keys"`
+}
+
+// inputSourceLabel names a data source for the --debug dump.
+func inputSourceLabel(source DataSource, name string) string {
+	//nolint:exhaustive
+	switch source {
+	case SourceStdin:
+		return InputSourceStdin
+	case SourceFile:
+		return "file:" + name
+	case SourceEnvKey:
+		return "envkey:" + name
+	default:
+		return InputSourceEnv
+	}
+}
+
+// newInputDump builds the dump of the input data, before it was placed under rootKey. sources
+// maps each key to the label of the source which supplied it. Values are masked by redactor
+// at their path in the template context.
+func newInputDump(data map[string]interface{}, sources map[string]string, rootKey string,
+	redactor *redact.Redactor,
+) *InputDump {
+	var keyPrefix []string
+	if rootKey != "" {
+		keyPrefix = []string{rootKey}
+	}
+	dump := &InputDump{Keys: make([]*InputDumpEntry, 0, len(data))}
+	for key, value := range data {
+		keyPath := append(append([]string{}, keyPrefix...), key)
+		dump.Keys = append(dump.Keys, &InputDumpEntry{
+			Key:     key,
+			Source:  sources[key],
+			RootKey: rootKey,
+			Value:   jsonValue(redactor.DataAt(keyPath, value)),
+		})
+	}
+	sort.Slice(dump.Keys, func(i, j int) bool { return dump.Keys[i].Key < dump.Keys[j].Key })
+	return dump
+}
+
+// jsonValue converts the map[interface{}]interface{} maps produced by the YAML parser so the
+// value can be serialized as JSON.
+func jsonValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			result[fmt.Sprint(k)] = jsonValue(v)
+		}
+		return result
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			result[k] = jsonValue(v)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for idx, v := range value {
+			result[idx] = jsonValue(v)
+		}
+		return result
+	default:
+		return value
+	}
+}
+
+// write serializes the dump as "json", "yaml" or a "table" of keys, sources and compact JSON
+// values.
+func (d *InputDump) write(w io.Writer, format string) error {
+	var data []byte
+	var err error
+	switch format {
+	case "yaml", "yml":
+		data, err = yaml.Marshal(d)
+	case "table":
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0) //nolint:mnd
+		_, _ = fmt.Fprintln(table, "KEY\tSOURCE\tVALUE")
+		for _, entry := range d.Keys {
+			key := entry.Key
+			if entry.RootKey != "" {
+				key = entry.RootKey + "." + key
+			}
+			value, err := json.Marshal(entry.Value)
+			if err != nil {
+				return errors.Wrap(err, "input dump: serialization failed")
+			}
+			_, _ = fmt.Fprintf(table, "%s\t%s\t%s\n", key, entry.Source, value)
+		}
+		return errors.Wrap(table.Flush(), "input dump: write failed")
+	default:
+		data, err = json.MarshalIndent(d, "", "  ")
+		data = append(data, '\n')
+	}
+	if err != nil {
+		return errors.Wrap(err, "input dump: serialization failed")
+	}
+	_, err = w.Write(data)
+	return errors.Wrap(err, "input dump: write failed")
+}
@@ -9,7 +9,6 @@ python.
 package entrypoint
 
 import (
-	"archive/tar"
 	"bufio"
 	"bytes"
 	"encoding/json"
@@ -19,10 +18,13 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/wrouesnel/p2cli/pkg/fileconsts"
+	"github.com/wrouesnel/p2cli/pkg/redact"
 	"github.com/wrouesnel/p2cli/version"
 
 	"github.com/alecthomas/kong"
@@ -88,7 +90,12 @@ type Options struct {
 		Format string `default:"console" enum:"console,json"  help:"logging format (${enum})"`
 	} `embed:"" prefix:"logging."`
 
-	DumpInputData bool `help:"Print Go serialization to stderr and then exit" name:"debug"`
+	DumpInputData bool   `help:"Print the input data, and the source of each key, to stderr" name:"debug"`
+	DumpFormat    string `default:"json" enum:"json,yaml,table" help:"Format of the --debug input dump (${enum})"`
+
+	RedactKeys   []string `help:"Additional key patterns whose values are masked in debug output and logs. Patterns are case insensitive globs matched against key names, or dotted paths if they contain a dot." name:"redact-key"`
+	RedactSchema string   `help:"JSON Schema (JSON or YAML) of the input. Values declared writeOnly, format: password or x-sensitive are masked in debug output and logs."`
+	NoRedact     bool     `help:"Do not mask sensitive values in debug output and logs"`
 
 	UseEnvKey    bool   `help:"Treat --input as an environment key name to read. This is equivalent to specifying --format=envkey"`
 	Format       string `default:"auto"                                                                                            enum:"auto,env,envkey,json,yml,yaml" help:"Input data format (may specify multiple values)" short:"f"`
@@ -97,16 +104,31 @@ type Options struct {
 	DataFile     string `help:"Input data path. Leave blank for stdin."                                                            name:"input"                         short:"i"`
 	OutputFile   string `help:"Output file. Leave blank for stdout."                                                               name:"output"                        short:"o"`
 
-	TarFile string `default:"" help:"Output content as a tar file with the given name or to stdout (-)" name:"tar"`
+	TarFile         string `default:"" help:"Output content as a tar file with the given name or to stdout (-)" name:"tar"`
+	ZipFile         string `default:"" help:"Output content as a zip file with the given name or to stdout (-)" name:"zip"`
+	CpioFile        string `default:"" help:"Output content as a newc cpio archive with the given name or to stdout (-)" name:"cpio"`
+	OCIDir          string `default:"" help:"Output content as a single layer image in the given OCI image layout directory" name:"oci"`
+	OCIPlatform     string `default:"${oci_platform}" help:"Platform (os/arch[/variant]) recorded in the --oci image" name:"oci-platform"`
+	OCIRef          string `default:"latest" help:"Reference name of the --oci image in the layout index" name:"oci-ref"`
+	TarCompression  string `default:"auto" enum:"auto,none,gzip,zstd" help:"Compression for --tar, --cpio and --oci layer output (${enum}). auto selects by file extension (.gz, .tgz, .zst), or gzip for --oci."`
+	SourceDateEpoch string `help:"Time in seconds since the Unix epoch used for archive entry modification times and pinned as now() in templates. Defaults to $SOURCE_DATE_EPOCH, or 0 for archive entries if unset."`
 
 	CustomFilters     string `help:"Enable custom P2 filters"                                              name:"enable-filters"`
 	CustomFilterNoops bool   `help:"Enable all custom filters in no-op mode. Supercedes --enable-filters." name:"enable-noop-filters"`
 
 	Autoescape bool `help:"Enable autoescaping"`
 
+	AllowRandom bool `help:"Allow the uuid, random_string, random_int and password filters to generate non-deterministic output when given an empty seed"`
+
 	DirectoryMode     bool   `help:"Treat template path as directory-tree, output path as target directory"`
 	FilenameSubstrDel string `help:"Delete a given substring in the output filename (only applies to --directory-mode)" name:"directory-mode-filename-substr-del"`
 
+	Prune          bool   `help:"Delete output files, including those created by write_file, generated by a previous run which were not generated by this run (only applies to --directory-mode)"`
+	PruneStateFile string `default:"${prune_state_file}" help:"State file recording generated files for --prune. Relative paths are resolved against the output directory."`
+
+	Manifest       string `help:"Write a manifest of every generated output to the given file"`
+	ManifestFormat string `default:"auto" enum:"auto,json,yaml" help:"Manifest format (${enum}). auto selects by file extension."`
+
 	InputRootKey string `help:"If specified, the input will be placed under a common subkey rather then in the root context. Use this when the input may contain invalid root context names."`
 
 	Version kong.VersionFlag `help:"Print the version and exit"`
@@ -127,7 +149,9 @@ var customFilters = map[string]CustomFilterSpec{
 	"make_dirs":  {filterMakeDirs, filterNoopPassthru},
 }
 
-func readRawInput(env map[string]string, stdIn io.Reader, name string, source DataSource) ([]byte, error) {
+// readRawInput reads input data from the given source. age encrypted input, and sops encrypted
+// input of the given format, is decrypted before it is returned.
+func readRawInput(env map[string]string, stdIn io.Reader, name string, source DataSource, format SupportedType) ([]byte, error) {
 	logger := zap.L()
 	var data []byte
 	var err error
@@ -152,9 +176,60 @@ func readRawInput(env map[string]string, stdIn io.Reader, name string, source Da
 		logger.Error("Could not read data", zap.Error(err), zap.String("filename", name))
 		return []byte{}, errors.Wrap(err, "readRawInput")
 	}
+
+	data, err = decryptInput(env, data, format)
+	if err != nil {
+		logger.Error("Could not decrypt data", zap.Error(err), zap.String("filename", name))
+		return []byte{}, errors.Wrap(err, "readRawInput")
+	}
 	return data, nil
 }
 
+// redactEnvVar masks the value of a raw KEY=value line for an error message. The redaction
+// schema is not loaded until the input has been parsed, so the value is always masked, and a
+// line without a key is masked entirely.
+func redactEnvVar(keyval string) string {
+	key, _, found := strings.Cut(keyval, "=")
+	if !found {
+		return redact.Mask
+	}
+	return key + "=" + redact.Mask
+}
+
+// reportTemplateError prints the annotated source snippet of a template error to w, masked by
+// mask, and returns log fields locating the error. The error itself is only logged when no
+// snippet was printed, so it is not reported twice.
+func reportTemplateError(w io.Writer, mask func(string) string, err error) []zap.Field {
+	var terr *templating.TemplateError
+	if !errors.As(err, &terr) {
+		return []zap.Field{zap.Error(err)}
+	}
+	_, _ = io.WriteString(w, mask(terr.Snippet()))
+	return []zap.Field{
+		zap.String("file", terr.Filename),
+		zap.Int("line", terr.Line),
+		zap.Int("column", terr.Column),
+		zap.String("token", terr.Token),
+	}
+}
+
+// readRedactSchema loads a JSON or YAML schema of the input. With an input root key the schema
+// is nested under it to match the template context.
+func readRedactSchema(schemaFile string, rootKey string) (interface{}, error) {
+	data, err := os.ReadFile(schemaFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "readRedactSchema")
+	}
+	var schema interface{}
+	if err := yaml.Unmarshal(data, &schema); err != nil {
+		return nil, errors.Wrap(err, "readRedactSchema")
+	}
+	if rootKey != "" {
+		schema = map[string]interface{}{"properties": map[string]interface{}{rootKey: schema}}
+	}
+	return schema, nil
+}
+
 type LaunchArgs struct {
 	StdIn  io.Reader
 	StdOut io.Writer
@@ -180,7 +255,9 @@ func Entrypoint(args LaunchArgs) int {
 
 	// Command line parsing can now happen
 	parser := lo.Must(kong.New(&options, kong.Description(version.Description), kong.Vars{
-		"version": version.Version,
+		"version":          version.Version,
+		"prune_state_file": DefaultPruneStateFile,
+		"oci_platform":     DefaultOCIPlatform,
 	}))
 	_, err = parser.Parse(args.Args)
 	if err != nil {
@@ -195,7 +272,26 @@ func Entrypoint(args LaunchArgs) int {
 	}
 	logConfig.Encoding = options.Logging.Format
 
-	logger, err := logConfig.Build()
+	// Sensitive values are masked in logs and debug output unless redaction is disabled.
+	redactPatterns := options.RedactKeys
+	if !options.NoRedact {
+		redactPatterns = append(append([]string{}, redact.DefaultKeyPatterns...), options.RedactKeys...)
+	}
+	redactor, err := redact.New(redactPatterns)
+	if err != nil {
+		_, _ = fmt.Fprintf(args.StdErr, "Argument error: %s", err.Error())
+		return 1
+	}
+	var logOptions []zap.Option
+	maskText := func(text string) string { return text }
+	maskEnvVar := maskText
+	if !options.NoRedact {
+		logOptions = append(logOptions, zap.WrapCore(redactor.Core))
+		maskText = redactor.String
+		maskEnvVar = redactEnvVar
+	}
+
+	logger, err := logConfig.Build(logOptions...)
 	if err != nil {
 		// Error unhandled since this is a very early failure
 		for _, line := range deferredLogs {
@@ -208,6 +304,12 @@ func Entrypoint(args LaunchArgs) int {
 	// Install as the global logger
 	zap.ReplaceGlobals(logger)
 
+	archiveFormat, archiveName, err := options.archiveOutput()
+	if err != nil {
+		logger.Error("Invalid archive output options", zap.Error(err))
+		return 1
+	}
+
 	//nolint:nestif
 	if options.DirectoryMode {
 		tst, _ := os.Stat(options.TemplateFile)
@@ -222,17 +324,48 @@ func Entrypoint(args LaunchArgs) int {
 				logger.Error("Output path must be an existing directory in directory mode", zap.String("template_file", options.TemplateFile))
 				return 1
 			}
-		} else if options.TarFile == "" {
-			// Allow non-existent output path if outputting to a tar file
+		} else if archiveFormat == "" {
+			// Allow non-existent output path if outputting to an archive file
 			logger.Error("Error calling stat on output path", zap.Error(err))
 			return 1
 		}
 	}
 
+	if options.Prune {
+		if !options.DirectoryMode {
+			logger.Error("--prune can only be used with --directory-mode")
+			return 1
+		}
+		if archiveFormat != "" {
+			logger.Error("--prune cannot be used with archive outputs")
+			return 1
+		}
+	}
+
+	// filterSet is passed to executeTemplate so it can vary parameters within the filter space as it goes.
+	filterSet := templating.FilterSet{OutputFileName: "", Chown: os.Chown, Chmod: os.Chmod, AllowRandom: options.AllowRandom, Redactor: redactor}
+
+	// inputMaps maps output paths to the template which generates them.
+	inputMaps := make(map[string]string)
+
+	// writtenFiles records the files created by write_file for --prune.
+	var writtenFiles []string
+
+	var manifest *outputManifest
+	manifestPath := ""
+	if options.Manifest != "" {
+		manifest = newOutputManifest(&filterSet, inputMaps)
+		manifestPath, err = filepath.Abs(options.Manifest)
+		if err != nil {
+			logger.Error("Could not determine absolute path of manifest file", zap.Error(err))
+			return 1
+		}
+	}
+
 	// Register custom filter functions.
 	if options.CustomFilterNoops {
 		for filter, spec := range customFilters {
-			_ = pongo2.RegisterFilter(filter, spec.NoopFunc)
+			registerFilter(filter, spec.NoopFunc)
 		}
 	} else if options.CustomFilters != "" {
 		for _, filter := range strings.Split(options.CustomFilters, ",") {
@@ -242,35 +375,137 @@ func Entrypoint(args LaunchArgs) int {
 				return 1
 			}
 
-			_ = pongo2.RegisterFilter(filter, spec.FilterFunc)
+			filterFunc := spec.FilterFunc
+			if manifest != nil && filter == "write_file" {
+				filterFunc = manifest.wrapWriteFile(filterFunc)
+			}
+			if options.Prune && filter == "write_file" {
+				filterFunc = recordWriteFile(filterFunc, &writtenFiles)
+			}
+
+			registerFilter(filter, filterFunc)
 		}
 	}
 
-	// filterSet is passed to executeTemplate so it can vary parameters within the filter space as it goes.
-	filterSet := templating.FilterSet{OutputFileName: "", Chown: os.Chown, Chmod: os.Chmod}
-
 	// Register the default custom filters. These are replaced each file execution later, but we
 	// need the names in-scope here.
-	_ = pongo2.RegisterFilter("SetOwner", filterSet.FilterSetOwner)
-	_ = pongo2.RegisterFilter("SetGroup", filterSet.FilterSetGroup)
-	_ = pongo2.RegisterFilter("SetMode", filterSet.FilterSetMode)
+	registerFilter("SetOwner", filterSet.FilterSetOwner)
+	registerFilter("SetGroup", filterSet.FilterSetGroup)
+	registerFilter("SetMode", filterSet.FilterSetMode)
 
 	// Standard suite of custom helpers
-	_ = pongo2.RegisterFilter("indent", filterSet.FilterIndent)
-	_ = pongo2.RegisterFilter("replace", filterSet.FilterReplace)
-
-	_ = pongo2.RegisterFilter("to_json", filterSet.FilterToJSON)
-	_ = pongo2.RegisterFilter("to_yaml", filterSet.FilterToYAML)
-	_ = pongo2.RegisterFilter("to_toml", filterSet.FilterToTOML)
-
-	_ = pongo2.RegisterFilter("to_base64", filterSet.FilterToBase64)
-	_ = pongo2.RegisterFilter("from_base64", filterSet.FilterFromBase64)
-
-	_ = pongo2.RegisterFilter("string", filterSet.FilterString)
-	_ = pongo2.RegisterFilter("bytes", filterSet.FilterBytes)
-
-	_ = pongo2.RegisterFilter("to_gzip", filterSet.FilterToGzip)
-	_ = pongo2.RegisterFilter("from_gzip", filterSet.FilterFromGzip)
+	registerFilter("indent", filterSet.FilterIndent)
+	registerFilter("replace", filterSet.FilterReplace)
+
+	registerFilter("regex_match", filterSet.FilterRegexMatch)
+	registerFilter("regex_replace", filterSet.FilterRegexReplace)
+	registerFilter("regex_findall", filterSet.FilterRegexFindall)
+	registerFilter("regex_split", filterSet.FilterRegexSplit)
+
+	registerFilter("to_json", filterSet.FilterToJSON)
+	registerFilter("to_yaml", filterSet.FilterToYAML)
+	registerFilter("to_toml", filterSet.FilterToTOML)
+	registerFilter("to_ini", filterSet.FilterToINI)
+	registerFilter("to_env", filterSet.FilterToEnv)
+	registerFilter("to_properties", filterSet.FilterToProperties)
+	registerFilter("to_xml", filterSet.FilterToXML)
+	registerFilter("to_hcl", filterSet.FilterToHCL)
+
+	registerFilter("from_json", filterSet.FilterFromJSON)
+	registerFilter("from_yaml", filterSet.FilterFromYAML)
+	registerFilter("from_toml", filterSet.FilterFromTOML)
+	registerFilter("from_ini", filterSet.FilterFromINI)
+
+	registerFilter("to_base64", filterSet.FilterToBase64)
+	registerFilter("from_base64", filterSet.FilterFromBase64)
+
+	registerFilter("string", filterSet.FilterString)
+	registerFilter("bytes", filterSet.FilterBytes)
+
+	registerFilter("to_gzip", filterSet.FilterToGzip)
+	registerFilter("from_gzip", filterSet.FilterFromGzip)
+
+	registerFilter("md5", filterSet.FilterMD5)
+	registerFilter("sha1", filterSet.FilterSHA1)
+	registerFilter("sha256", filterSet.FilterSHA256)
+	registerFilter("sha512", filterSet.FilterSHA512)
+	registerFilter("crc32", filterSet.FilterCRC32)
+	registerFilter("hmac_sha256", filterSet.FilterHMACSHA256)
+
+	registerFilter("bcrypt", filterSet.FilterBcrypt)
+	registerFilter("sha512_crypt", filterSet.FilterSHA512Crypt)
+	registerFilter("argon2id", filterSet.FilterArgon2id)
+	registerFilter("htpasswd", filterSet.FilterHtpasswd)
+
+	registerFilter("uuid", filterSet.FilterUUID)
+	registerFilter("random_string", filterSet.FilterRandomString)
+	registerFilter("random_int", filterSet.FilterRandomInt)
+	registerFilter("password", filterSet.FilterPassword)
+
+	registerFilter("date_format", filterSet.FilterDateFormat)
+	registerFilter("date_parse", filterSet.FilterDateParse)
+	registerFilter("duration", filterSet.FilterDuration)
+	registerFilter("unix_timestamp", filterSet.FilterUnixTimestamp)
+
+	registerFilter("cidr_host", filterSet.FilterCIDRHost)
+	registerFilter("cidr_subnet", filterSet.FilterCIDRSubnet)
+	registerFilter("cidr_netmask", filterSet.FilterCIDRNetmask)
+	registerFilter("ip_in_cidr", filterSet.FilterIPInCIDR)
+	registerFilter("ip_version", filterSet.FilterIPVersion)
+	registerFilter("reverse_dns_name", filterSet.FilterReverseDNSName)
+
+	registerFilter("semver_parse", filterSet.FilterSemverParse)
+	registerFilter("semver_compare", filterSet.FilterSemverCompare)
+	registerFilter("semver_satisfies", filterSet.FilterSemverSatisfies)
+	registerFilter("semver_bump", filterSet.FilterSemverBump)
+
+	registerFilter("shell_quote", filterSet.FilterShellQuote)
+	registerFilter("systemd_escape", filterSet.FilterSystemdEscape)
+	registerFilter("nginx_quote", filterSet.FilterNginxQuote)
+	registerFilter("json_string", filterSet.FilterJSONString)
+	registerFilter("xml_escape", filterSet.FilterXMLEscape)
+	registerFilter("yaml_quote", filterSet.FilterYAMLQuote)
+	registerFilter("regex_escape", filterSet.FilterRegexEscape)
+
+	registerFilter("merge", filterSet.FilterMerge)
+	registerFilter("pick", filterSet.FilterPick)
+	registerFilter("omit", filterSet.FilterOmit)
+	registerFilter("dict_keys", filterSet.FilterDictKeys)
+	registerFilter("dict_values", filterSet.FilterDictValues)
+	registerFilter("flatten", filterSet.FilterFlatten)
+	registerFilter("unique", filterSet.FilterUnique)
+	registerFilter("group_by", filterSet.FilterGroupBy)
+	registerFilter("sort_by", filterSet.FilterSortBy)
+	registerFilter("zip", filterSet.FilterZip)
+
+	registerFilter("query", filterSet.FilterQuery)
+
+	registerFilter("snake_case", filterSet.FilterSnakeCase)
+	registerFilter("camel_case", filterSet.FilterCamelCase)
+	registerFilter("pascal_case", filterSet.FilterPascalCase)
+	registerFilter("kebab_case", filterSet.FilterKebabCase)
+	registerFilter("screaming_snake", filterSet.FilterScreamingSnake)
+	registerFilter("slugify", filterSet.FilterSlugify)
+	registerFilter("title_words", filterSet.FilterTitleWords)
+
+	registerFilter("basename", filterSet.FilterBasename)
+	registerFilter("dirname", filterSet.FilterDirname)
+	registerFilter("path_join", filterSet.FilterPathJoin)
+	registerFilter("path_ext", filterSet.FilterPathExt)
+	registerFilter("path_rel", filterSet.FilterPathRel)
+	registerFilter("path_abs", filterSet.FilterPathAbs)
+
+	registerFilter("x509_parse", filterSet.FilterX509Parse)
+	registerFilter("tls_ca", filterSet.FilterTLSCA)
+	registerFilter("tls_cert", filterSet.FilterTLSCert)
+
+	registerFilter("ssh_public_key", filterSet.FilterSSHPublicKey)
+	registerFilter("ssh_fingerprint", filterSet.FilterSSHFingerprint)
+	registerFilter("ssh_keygen", filterSet.FilterSSHKeygen)
+	registerFilter("wireguard_public_key", filterSet.FilterWireguardPublicKey)
+	registerFilter("wireguard_keygen", filterSet.FilterWireguardKeygen)
+
+	registerFilter("secret", filterSet.FilterSecret)
 
 	// Determine mode of operations
 	var fileFormat SupportedType
@@ -324,10 +559,20 @@ func Entrypoint(args LaunchArgs) int {
 		err = func(inputData map[string]interface{}) error {
 			//nolint:nestif
 			if inputSource != SourceEnv {
-				rawInput, err := readRawInput(args.Env, args.StdIn, options.DataFile, inputSource)
+				rawInput, err := readRawInput(args.Env, args.StdIn, options.DataFile, inputSource, fileFormat)
 				if err != nil {
 					return err
 				}
+				if isSopsEnv(rawInput) {
+					values, err := decryptSopsEnv(args.Env, rawInput)
+					if err != nil {
+						return err
+					}
+					for k, v := range values {
+						inputData[k] = v
+					}
+					return nil
+				}
 				lineScanner := bufio.NewScanner(bytes.NewReader(rawInput))
 				for lineScanner.Scan() {
 					keyval := lineScanner.Text()
@@ -336,7 +581,7 @@ func Entrypoint(args LaunchArgs) int {
 					if len(splitKeyVal) != expectedFragments {
 						return error(errdefs.EnvironmentVariablesError{
 							Reason:    "Could not find an equals value to split on",
-							RawEnvVar: keyval,
+							RawEnvVar: maskEnvVar(keyval),
 						})
 					}
 					// File values should support sh-escaped strings, whereas the
@@ -345,7 +590,7 @@ func Entrypoint(args LaunchArgs) int {
 					if err != nil {
 						return error(errdefs.EnvironmentVariablesError{
 							Reason:    err.Error(),
-							RawEnvVar: keyval,
+							RawEnvVar: maskEnvVar(keyval),
 						})
 					}
 
@@ -354,7 +599,7 @@ func Entrypoint(args LaunchArgs) int {
 					if len(values) > 1 {
 						return error(errdefs.EnvironmentVariablesError{
 							Reason:    "Improperly escaped environment variable. p2 does not parse arrays.",
-							RawEnvVar: keyval,
+							RawEnvVar: maskEnvVar(keyval),
 						})
 					}
 
@@ -369,14 +614,14 @@ func Entrypoint(args LaunchArgs) int {
 		}(inputData)
 	case TypeYAML:
 		var rawInput []byte
-		rawInput, err = readRawInput(args.Env, args.StdIn, options.DataFile, inputSource)
+		rawInput, err = readRawInput(args.Env, args.StdIn, options.DataFile, inputSource, fileFormat)
 		if err != nil {
 			return 1
 		}
 		err = yaml.Unmarshal(rawInput, &inputData)
 	case TypeJSON:
 		var rawInput []byte
-		rawInput, err = readRawInput(args.Env, args.StdIn, options.DataFile, inputSource)
+		rawInput, err = readRawInput(args.Env, args.StdIn, options.DataFile, inputSource, fileFormat)
 		if err != nil {
 			return 1
 		}
@@ -394,21 +639,46 @@ func Entrypoint(args LaunchArgs) int {
 		return 1
 	}
 
+	// Record where each key came from for the --debug dump.
+	inputSources := make(map[string]string, len(inputData))
+	for k := range inputData {
+		inputSources[k] = inputSourceLabel(inputSource, options.DataFile)
+	}
+
 	if options.IncludeEnv {
 		logger.Info("Including environment variables")
 		for k, v := range args.Env {
 			inputData[k] = v
+			inputSources[k] = InputSourceEnv
 		}
 	}
 
+	if options.RedactSchema != "" && !options.NoRedact {
+		schema, err := readRedactSchema(options.RedactSchema, options.InputRootKey)
+		if err != nil {
+			logger.Error("Could not read redaction schema", zap.Error(err), zap.String("schema", options.RedactSchema))
+			return 1
+		}
+		redactor.AddSchema(schema)
+	}
+
+	// The dump lists the input keys before they are placed under the root key.
+	dumpData := inputData
 	if options.InputRootKey != "" {
 		oldInputData := inputData
 		inputData = make(map[string]interface{})
 		inputData[options.InputRootKey] = oldInputData
 	}
 
+	// Record sensitive values before anything can log them.
+	redactor.Scan(inputData)
+
 	if options.DumpInputData {
-		_, _ = fmt.Fprintln(args.StdErr, inputData)
+		dump := newInputDump(dumpData, inputSources, options.InputRootKey, redactor)
+		if err := dump.write(args.StdErr, options.DumpFormat); err != nil {
+			logger.Error("Could not write input dump", zap.Error(err))
+			return 1
+		}
 	}
 
 	if !options.Autoescape {
@@ -417,7 +687,6 @@ func Entrypoint(args LaunchArgs) int {
 
 	// Load all templates and their relative paths
 	templates := make(map[string]*templating.LoadedTemplate)
-	inputMaps := make(map[string]string)
 
 	rootDir := options.OutputFile
 	if !options.DirectoryMode {
@@ -436,6 +705,8 @@ func Entrypoint(args LaunchArgs) int {
 
 	//nolint:nestif
 	if options.DirectoryMode {
+		// Every template is parsed before giving up so all load errors are reported at once.
+		loadErrors := 0
 		err := filepath.Walk(options.TemplateFile, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
 				logger.Error("Error walking directory tree", zap.Error(err))
@@ -452,10 +723,11 @@ func Entrypoint(args LaunchArgs) int {
 			}
 
 			logger.Debug("Template file", zap.String("template_file", relPath))
-			tmpl := templating.LoadTemplate(path)
-			if tmpl == nil {
-				logger.Error("Error loading template", zap.String("path", path))
-				return errors.New("Error loading template")
+			tmpl, err := templating.LoadTemplate(path)
+			if err != nil {
+				logger.Error("Error loading template", append(reportTemplateError(args.StdErr, maskText, err), zap.String("path", path))...)
+				loadErrors++
+				return nil
 			}
 
 			newRelPath := transformFileName(relPath, options)
@@ -481,8 +753,8 @@ func Entrypoint(args LaunchArgs) int {
 
 			ctx := make(pongo2.Context)
 			ctx["p2"] = p2cliCtx
-			templateSet := pongo2.NewSet(outputPath, pongo2.DefaultLoader)
-			templateSet.Globals.Update(ctx)
+			ctx["now"] = filterSet.CurrentTime
+			tmpl.TemplateSet.Globals.Update(ctx)
 
 			templates[outputPath] = tmpl
 			inputMaps[outputPath] = path
@@ -492,11 +764,15 @@ func Entrypoint(args LaunchArgs) int {
 			logger.Error("Error while walking input directory path", zap.String("template_file", options.TemplateFile))
 			return 1
 		}
+		if loadErrors > 0 {
+			logger.Error("Errors encountered loading templates", zap.Int("failed", loadErrors))
+			return 1
+		}
 	} else {
 		// Just load the template as the output file
-		tmpl := templating.LoadTemplate(options.TemplateFile)
-		if tmpl == nil {
-			logger.Error("Error loading template:", zap.String("template_file", options.TemplateFile))
+		tmpl, err := templating.LoadTemplate(options.TemplateFile)
+		if err != nil {
+			logger.Error("Error loading template", append(reportTemplateError(args.StdErr, maskText, err), zap.String("template_file", options.TemplateFile))...)
 			return 1
 		}
 
@@ -518,89 +794,95 @@ func Entrypoint(args LaunchArgs) int {
 
 		ctx := make(pongo2.Context)
 		ctx["p2"] = p2cliCtx
+		ctx["now"] = filterSet.CurrentTime
 
 		tmpl.TemplateSet.Globals.Update(ctx)
 
 		ctx["p2"] = p2cliCtx
 
 		templates[outputPath] = tmpl
-		inputMaps[outputPath] = templating.StdOutVal
+		inputMaps[outputPath] = options.TemplateFile
+	}
+
+	sourceDateEpoch, err := parseSourceDateEpoch(options.SourceDateEpoch, args.Env)
+	if err != nil {
+		logger.Error("Could not parse source date epoch", zap.Error(err))
+		return 1
+	}
+	if options.SourceDateEpoch != "" || args.Env["SOURCE_DATE_EPOCH"] != "" {
+		// Pin the template clock so date functions render reproducibly.
+		filterSet.Now = func() time.Time { return sourceDateEpoch }
 	}
 
 	// Configure output path
 	var templateEngine *templating.TemplateEngine
+	// closeOutput is called once all templates have been executed successfully.
+	var closeOutput func() error
 	switch {
-	case options.TarFile != "":
-		var fileOut io.Writer
-		if options.TarFile == "-" {
-			fileOut = args.StdOut
-		} else {
-			fileOut, err = os.OpenFile(options.TarFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(fileconsts.OS_ALL_RWX))
+	case archiveFormat != "":
+		var fileOut io.WriteCloser
+		switch {
+		case archiveFormat == ArchiveFormatOCI:
+			if err := os.MkdirAll(archiveName, os.FileMode(fileconsts.OS_ALL_RWX)); err != nil {
+				logger.Error("Error creating OCI image layout directory", zap.Error(err))
+				return 1
+			}
+			fileOut, err = newOCILayoutWriter(archiveName, options.OCIRef, options.OCIPlatform, sourceDateEpoch, options.TarCompression)
 			if err != nil {
-				logger.Error("Error opening tar file for output", zap.Error(err))
+				logger.Error("Error setting up OCI image layout output", zap.Error(err))
+				return 1
+			}
+		case archiveName == "-":
+			fileOut = nopWriteCloser{args.StdOut}
+		default:
+			fileOut, err = os.OpenFile(archiveName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(fileconsts.OS_ALL_RW))
+			if err != nil {
+				logger.Error("Error opening archive file for output", zap.Error(err), zap.String("format", archiveFormat))
 				return 1
 			}
 		}
-		tarWriter := tar.NewWriter(fileOut)
-		templateEngine = &templating.TemplateEngine{
-			PrepareOutput: func(inputData pongo2.Context, outputPath string) (io.Writer, func() error, error) {
-				relPath, err := filepath.Rel(rootDir, outputPath)
-				if err != nil {
-					return nil, nil, fmt.Errorf("could not determine relative output path: %w", err)
-				}
-
-				// Setup a new header
-				header := &tar.Header{
-					Typeflag: tar.TypeReg,
-					Name:     filepath.Join(options.OutputFile, relPath),
-					//Linkname:   "",
-					Size:       0,
-					Mode:       fileconsts.OS_ALL_RWX,
-					Uid:        0,
-					Gid:        0,
-					Uname:      "",
-					Gname:      "",
-					ModTime:    time.Time{},
-					AccessTime: time.Time{},
-					ChangeTime: time.Time{},
-					//Devmajor:   0,
-					//Devminor:   0,
-					//Xattrs:     nil,
-					//PAXRecords: nil,
-					//Format:     0,
-				}
-
-				// Modify filterSet so we receive the Chown/Chmod operations
-				filterSet.Chown = func(name string, uid, gid int) error {
-					if uid != -1 {
-						header.Uid = uid
-					}
-					if gid != -1 {
-						header.Gid = gid
-					}
-					return nil
-				}
-				filterSet.Chmod = func(name string, mode os.FileMode) error {
-					header.Mode = int64(mode)
-					return nil
-				}
 
-				// Setup a buffer for the output
-				buf := new(bytes.Buffer)
+		compression := options.TarCompression
+		if compression == CompressionAuto {
+			compression = compressionForName(archiveName)
+		}
+		if archiveFormat == ArchiveFormatZip || archiveFormat == ArchiveFormatOCI {
+			// Zip files compress their members individually, and OCI layers are compressed
+			// after the diffID is calculated.
+			compression = CompressionNone
+		}
+		compressor, err := newCompressor(fileOut, compression)
+		if err != nil {
+			logger.Error("Error setting up archive compression", zap.Error(err))
+			return 1
+		}
 
-				finalizer := func() error {
-					header.Size = int64(buf.Len())
-					if err := tarWriter.WriteHeader(header); err != nil {
-						return errors.Wrap(err, "entrypoint: write header for tar file failed")
-					}
-					if _, err := tarWriter.Write(buf.Bytes()); err != nil {
-						return errors.Wrap(err, "entrypoint: write file body for tar file failed")
-					}
-					return nil
-				}
+		var archiveWriter archiveWriter
+		switch archiveFormat {
+		case ArchiveFormatZip:
+			archiveWriter = newZipArchiveWriter(compressor)
+		case ArchiveFormatCpio:
+			archiveWriter = newCpioArchiveWriter(compressor)
+		default:
+			archiveWriter = newTarArchiveWriter(compressor)
+		}
 
-				return buf, finalizer, nil
-			},
+		archive := newArchiveOutput(archiveWriter, &filterSet, rootDir, options.OutputFile, sourceDateEpoch)
+		templateEngine = &templating.TemplateEngine{
+			PrepareOutput: archive.PrepareOutput,
+		}
+		if manifest != nil {
+			// Archive outputs are recorded by their member name rather than a host path.
+			manifest.entryName = archive.entryName
+		}
+		closeOutput = func() error {
+			if err := archiveWriter.Close(); err != nil {
+				return err
+			}
+			if err := compressor.Close(); err != nil {
+				return errors.Wrap(err, "entrypoint: closing archive compressor failed")
+			}
+			return errors.Wrap(fileOut.Close(), "entrypoint: closing archive file failed")
 		}
 
 	case options.DirectoryMode:
@@ -667,10 +949,20 @@ func Entrypoint(args LaunchArgs) int {
 		}
 	}
 
+	if manifest != nil {
+		onDisk := archiveFormat == "" && options.OutputFile != "-" && options.OutputFile != ""
+		templateEngine.PrepareOutput = manifest.wrapPrepareOutput(onDisk, templateEngine.PrepareOutput)
+	}
+
+	// Execute templates in a stable order so archive outputs are reproducible.
+	outputPaths := lo.Keys(templates)
+	sort.Strings(outputPaths)
+
 	failed := false
-	for outputPath, tmpl := range templates {
+	for _, outputPath := range outputPaths {
+		tmpl := templates[outputPath]
 		if err := templateEngine.ExecuteTemplate(&filterSet, tmpl, inputData, outputPath); err != nil {
-			logger.Error("Failed to execute template", zap.Error(err), zap.String("template_path", inputMaps[outputPath]), zap.String("output_path", outputPath))
+			logger.Error("Failed to execute template", append(reportTemplateError(args.StdErr, maskText, err), zap.String("template_path", inputMaps[outputPath]), zap.String("output_path", outputPath))...)
 			failed = true
 		}
 	}
@@ -680,6 +972,31 @@ func Entrypoint(args LaunchArgs) int {
 		return 1
 	}
 
+	if closeOutput != nil {
+		if err := closeOutput(); err != nil {
+			logger.Error("Error finalizing output", zap.Error(err))
+			return 1
+		}
+	}
+
+	if options.Prune {
+		statePath := options.PruneStateFile
+		if !filepath.IsAbs(statePath) {
+			statePath = filepath.Join(rootDir, statePath)
+		}
+		if err := pruneOutputs(statePath, rootDir, append(lo.Keys(templates), writtenFiles...)); err != nil {
+			logger.Error("Error pruning stale output files", zap.Error(err), zap.String("state_file", statePath))
+			return 1
+		}
+	}
+
+	if manifest != nil {
+		if err := manifest.write(manifestPath, options.ManifestFormat); err != nil {
+			logger.Error("Error writing output manifest", zap.Error(err), zap.String("manifest", manifestPath))
+			return 1
+		}
+	}
+
 	return 0
 }
 
@@ -690,3 +1007,53 @@ func transformFileName(relPath string, options Options) string {
 	transformedFileName := strings.ReplaceAll(filename, options.FilenameSubstrDel, "")
 	return filepath.Join(filepath.Dir(relPath), transformedFileName)
 }
+
+// registerFilter registers a filter with pongo2, replacing any existing filter of the same name.
+// Filters are global to pongo2, so replacing ensures repeated invocations of Entrypoint bind
+// filters to the current FilterSet.
+func registerFilter(name string, fn pongo2.FilterFunction) {
+	if pongo2.FilterExists(name) {
+		_ = pongo2.ReplaceFilter(name, fn)
+		return
+	}
+	_ = pongo2.RegisterFilter(name, fn)
+}
+
+// archiveOutput returns the archive format and file name selected on the command line, or an
+// empty format if output is not to an archive.
+func (o Options) archiveOutput() (string, string, error) {
+	selected := lo.PickBy(map[string]string{
+		ArchiveFormatTar:  o.TarFile,
+		ArchiveFormatZip:  o.ZipFile,
+		ArchiveFormatCpio: o.CpioFile,
+		ArchiveFormatOCI:  o.OCIDir,
+	}, func(format string, name string) bool {
+		return name != ""
+	})
+
+	if len(selected) > 1 {
+		return "", "", errors.New("only one of --tar, --zip, --cpio or --oci may be specified")
+	}
+
+	for format, name := range selected {
+		return format, name, nil
+	}
+	return "", "", nil
+}
+
+// parseSourceDateEpoch determines the modification time used for archive entries. An explicit
+// value takes precedence over the SOURCE_DATE_EPOCH environment variable.
+func parseSourceDateEpoch(value string, env map[string]string) (time.Time, error) {
+	if value == "" {
+		value = env["SOURCE_DATE_EPOCH"]
+	}
+	if value == "" {
+		return time.Unix(0, 0).UTC(), nil
+	}
+
+	epoch, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("source date epoch must be an integer number of seconds: %w", err)
+	}
+	return time.Unix(epoch, 0).UTC(), nil
+}
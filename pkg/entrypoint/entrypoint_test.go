@@ -2,20 +2,31 @@ package entrypoint_test
 
 import (
 	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path"
 	"strings"
 	"testing"
 
+	"github.com/cavaliergopher/cpio"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 
 	"github.com/samber/lo"
 	"github.com/wrouesnel/p2cli/pkg/entrypoint"
 	"github.com/wrouesnel/p2cli/pkg/envutil"
+	"github.com/wrouesnel/p2cli/pkg/redact"
 
 	. "gopkg.in/check.v1"
+	"gopkg.in/yaml.v2"
 )
 
 // Hook up gocheck into the "go test" runner.
@@ -490,3 +501,1056 @@ func (s *p2Integration) TestInvalidEnvironmentVariables(c *C) {
 	c.Check(exit, Equals, 0)
 	c.Assert(exit, Equals, 0, Commentf("Exit code with invalid data in environment != 0"))
 }
+
+// TestDirectoryModePrune tests that --prune removes files generated by a previous run, but
+// leaves files which p2 did not generate alone.
+func (s *p2Integration) TestDirectoryModePrune(c *C) {
+	templateDir := c.MkDir()
+	testOutputDir := c.MkDir()
+
+	c.Assert(os.MkdirAll(path.Join(templateDir, "dir1"), os.FileMode(0777)), IsNil)
+	c.Assert(os.WriteFile(path.Join(templateDir, "dir1/keep"), []byte("keep"), os.FileMode(0644)), IsNil)
+	c.Assert(os.WriteFile(path.Join(templateDir, "dir1/removed"), []byte("removed"), os.FileMode(0644)), IsNil)
+
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args:   []string{"--directory-mode", "--prune", "-t", templateDir, "-o", testOutputDir},
+	}
+
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for directory mode with --prune != 0"))
+
+	_, err := os.Stat(path.Join(testOutputDir, entrypoint.DefaultPruneStateFile))
+	c.Assert(err, IsNil, Commentf("state file was not written"))
+
+	// Hand-placed files must survive pruning
+	c.Assert(os.WriteFile(path.Join(testOutputDir, "dir1/handplaced"), []byte("mine"), os.FileMode(0644)), IsNil)
+	c.Assert(os.Remove(path.Join(templateDir, "dir1/removed")), IsNil)
+
+	exit = entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for directory mode with --prune != 0"))
+
+	_, err = os.Stat(path.Join(testOutputDir, "dir1/keep"))
+	c.Check(err, IsNil, Commentf("current output file was pruned"))
+	_, err = os.Stat(path.Join(testOutputDir, "dir1/removed"))
+	c.Check(os.IsNotExist(err), Equals, true, Commentf("stale output file was not pruned"))
+	_, err = os.Stat(path.Join(testOutputDir, "dir1/handplaced"))
+	c.Check(err, IsNil, Commentf("hand-placed file was pruned"))
+}
+
+// TestDirectoryModePruneWriteFile tests that --prune removes files created by write_file in a
+// previous run which the current run no longer writes.
+func (s *p2Integration) TestDirectoryModePruneWriteFile(c *C) {
+	templateDir := c.MkDir()
+	testOutputDir := c.MkDir()
+
+	c.Assert(os.MkdirAll(path.Join(templateDir, "dir1"), os.FileMode(0777)), IsNil)
+	c.Assert(os.WriteFile(path.Join(templateDir, "dir1/gen"),
+		[]byte(`{{ "a"|write_file:"extra-a" }}{{ "b"|write_file:"extra-b" }}`), os.FileMode(0644)), IsNil)
+
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args: []string{"--directory-mode", "--prune", "--enable-filters=write_file",
+			"-t", templateDir, "-o", testOutputDir},
+	}
+
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for directory mode with --prune != 0"))
+	c.Check(string(MustReadFile(path.Join(testOutputDir, "dir1/extra-b"))), Equals, "b")
+
+	c.Assert(os.WriteFile(path.Join(templateDir, "dir1/gen"),
+		[]byte(`{{ "a"|write_file:"extra-a" }}`), os.FileMode(0644)), IsNil)
+
+	exit = entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for directory mode with --prune != 0"))
+
+	_, err := os.Stat(path.Join(testOutputDir, "dir1/extra-a"))
+	c.Check(err, IsNil, Commentf("current write_file output was pruned"))
+	_, err = os.Stat(path.Join(testOutputDir, "dir1/extra-b"))
+	c.Check(os.IsNotExist(err), Equals, true, Commentf("stale write_file output was not pruned"))
+}
+
+func (s *p2Integration) TestOutputManifest(c *C) {
+	testOutputDir := c.MkDir()
+	manifestPath := path.Join(c.MkDir(), "manifest.json")
+
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args: []string{"--directory-mode", "-t", "tests/directory-mode/templates",
+			"-o", testOutputDir, "--manifest", manifestPath},
+	}
+
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for directory mode with --manifest != 0"))
+
+	manifest := entrypoint.Manifest{}
+	c.Assert(json.Unmarshal(MustReadFile(manifestPath), &manifest), IsNil)
+	c.Assert(manifest.Outputs, HasLen, 3)
+
+	for _, entry := range manifest.Outputs {
+		content := MustReadFile(entry.Path)
+		c.Check(entry.Kind, Equals, entrypoint.ManifestKindTemplate)
+		c.Check(entry.Size, Equals, int64(len(content)))
+		c.Check(entry.SHA256, Equals, fmt.Sprintf("%x", sha256.Sum256(content)))
+		if strings.HasSuffix(entry.Path, "dir1/template1") {
+			c.Check(entry.Mode, Equals, "0640")
+			c.Check(entry.Template, Equals, "tests/directory-mode/templates/dir1/template1")
+		}
+	}
+}
+
+// TestOutputManifestWriteFile tests that the manifest records write_file outputs and the
+// ownership set by templates, and can be written as YAML.
+func (s *p2Integration) TestOutputManifestWriteFile(c *C) {
+	templateDir := c.MkDir()
+	testOutputDir := c.MkDir()
+	manifestPath := path.Join(c.MkDir(), "manifest.out")
+
+	uid, gid := os.Getuid(), os.Getgid()
+	c.Assert(os.WriteFile(path.Join(templateDir, "owned"), []byte(fmt.Sprintf(
+		`{{ %d|SetOwner }}{{ %d|SetGroup }}{{ "extra"|write_file:"extra" }}content`, uid, gid)),
+		os.FileMode(0644)), IsNil)
+
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args: []string{"--directory-mode", "--enable-filters=write_file", "-t", templateDir,
+			"-o", testOutputDir, "--manifest", manifestPath, "--manifest-format", "yaml"},
+	}
+
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for directory mode with --manifest != 0"))
+
+	manifest := entrypoint.Manifest{}
+	c.Assert(yaml.Unmarshal(MustReadFile(manifestPath), &manifest), IsNil)
+	c.Assert(manifest.Outputs, HasLen, 2)
+
+	// Outputs are sorted by path.
+	writeFileEntry, templateEntry := manifest.Outputs[0], manifest.Outputs[1]
+	c.Check(writeFileEntry.Kind, Equals, entrypoint.ManifestKindWriteFile)
+	c.Check(writeFileEntry.Path, Equals, path.Join(testOutputDir, "extra"))
+	c.Check(writeFileEntry.Template, Equals, path.Join(templateDir, "owned"))
+	c.Check(writeFileEntry.Size, Equals, int64(len("extra")))
+	c.Check(writeFileEntry.SHA256, Equals, fmt.Sprintf("%x", sha256.Sum256([]byte("extra"))))
+
+	c.Check(templateEntry.Kind, Equals, entrypoint.ManifestKindTemplate)
+	c.Check(templateEntry.Path, Equals, path.Join(testOutputDir, "owned"))
+	// write_file passes its input through to the template output.
+	c.Check(string(MustReadFile(templateEntry.Path)), Equals, "extracontent")
+	c.Check(templateEntry.Size, Equals, int64(len("extracontent")))
+	c.Assert(templateEntry.UID, NotNil)
+	c.Check(*templateEntry.UID, Equals, uid)
+	c.Assert(templateEntry.GID, NotNil)
+	c.Check(*templateEntry.GID, Equals, gid)
+}
+
+// TestOutputManifestArchive tests that archive outputs are recorded by their member name.
+func (s *p2Integration) TestOutputManifestArchive(c *C) {
+	tarName := path.Join(c.MkDir(), "output.tar")
+	manifestPath := path.Join(c.MkDir(), "manifest.json")
+
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args: []string{"--directory-mode", "-t", "tests/directory-mode/templates",
+			"--tar", tarName, "--manifest", manifestPath},
+	}
+
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for directory mode with --tar and --manifest != 0"))
+
+	members := map[string][]byte{}
+	tarReader := tar.NewReader(bytes.NewReader(MustReadFile(tarName)))
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		c.Assert(err, IsNil)
+		if header.Typeflag == tar.TypeReg {
+			members[header.Name] = lo.Must(io.ReadAll(tarReader))
+		}
+	}
+
+	manifest := entrypoint.Manifest{}
+	c.Assert(json.Unmarshal(MustReadFile(manifestPath), &manifest), IsNil)
+	c.Assert(manifest.Outputs, HasLen, len(members))
+	for _, entry := range manifest.Outputs {
+		content, found := members[entry.Path]
+		c.Assert(found, Equals, true, Commentf("%s is not an archive member", entry.Path))
+		c.Check(entry.SHA256, Equals, fmt.Sprintf("%x", sha256.Sum256(content)))
+	}
+}
+
+// TestTarFileIsReproducible tests that compressed tar output is byte-identical across runs
+// and contains directory entries with the requested modification time.
+func (s *p2Integration) TestTarFileIsReproducible(c *C) {
+	outputDir := c.MkDir()
+	tarNames := []string{path.Join(outputDir, "first.tar.gz"), path.Join(outputDir, "second.tar.gz")}
+
+	for _, tarName := range tarNames {
+		entrypointArgs := entrypoint.LaunchArgs{
+			StdIn:  os.Stdin,
+			StdOut: os.Stdout,
+			StdErr: os.Stderr,
+			Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+			Args: []string{"--directory-mode", "-t", "tests/directory-mode/templates",
+				"--tar", tarName, "--source-date-epoch", "1700000000"},
+		}
+
+		exit := entrypoint.Entrypoint(entrypointArgs)
+		c.Assert(exit, Equals, 0, Commentf("Exit code for directory mode with --tar != 0"))
+	}
+
+	c.Assert(MustReadFile(tarNames[0]), DeepEquals, MustReadFile(tarNames[1]))
+
+	gzipReader := lo.Must(gzip.NewReader(lo.Must(os.Open(tarNames[0]))))
+	tarReader := tar.NewReader(gzipReader)
+
+	names := []string{}
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		c.Assert(err, IsNil)
+		c.Check(header.ModTime.Unix(), Equals, int64(1700000000))
+		switch header.Name {
+		case "dir1/":
+			c.Check(header.Typeflag, Equals, byte(tar.TypeDir))
+			c.Check(header.Mode, Equals, int64(0755))
+		case "dir1/template1":
+			c.Check(header.Mode, Equals, int64(0640))
+		case "dir3/template3":
+			c.Check(header.Mode, Equals, int64(0644))
+		}
+		names = append(names, header.Name)
+	}
+
+	c.Check(names, DeepEquals, []string{"dir1/", "dir1/dir2/", "dir1/dir2/template2", "dir1/template1",
+		"dir3/", "dir3/template3"})
+}
+
+func (s *p2Integration) TestZipAndCpioDirectoryMode(c *C) {
+	outputDir := c.MkDir()
+	zipName := path.Join(outputDir, "output.zip")
+	cpioName := path.Join(outputDir, "output.cpio")
+
+	for _, archiveArgs := range [][]string{{"--zip", zipName}, {"--cpio", cpioName}} {
+		entrypointArgs := entrypoint.LaunchArgs{
+			StdIn:  os.Stdin,
+			StdOut: os.Stdout,
+			StdErr: os.Stderr,
+			Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+			Args:   append([]string{"--directory-mode", "-t", "tests/directory-mode/templates"}, archiveArgs...),
+		}
+
+		exit := entrypoint.Entrypoint(entrypointArgs)
+		c.Assert(exit, Equals, 0, Commentf("Exit code for directory mode with %s != 0", archiveArgs[0]))
+	}
+
+	expected := []string{"dir1/", "dir1/dir2/", "dir1/dir2/template2", "dir1/template1", "dir3/", "dir3/template3"}
+
+	zipReader := lo.Must(zip.OpenReader(zipName))
+	zipNames := []string{}
+	for _, f := range zipReader.File {
+		if f.Name == "dir1/template1" {
+			c.Check(f.Mode().Perm(), Equals, os.FileMode(0640))
+		}
+		zipNames = append(zipNames, f.Name)
+	}
+	c.Check(zipNames, DeepEquals, expected)
+
+	cpioReader := cpio.NewReader(lo.Must(os.Open(cpioName)))
+	cpioNames := []string{}
+	for {
+		header, err := cpioReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		c.Assert(err, IsNil)
+		name := header.Name
+		if header.Mode.IsDir() {
+			name += "/"
+		}
+		if header.Name == "dir1/template1" {
+			c.Check(header.Mode.Perm(), Equals, cpio.FileMode(0640))
+		}
+		cpioNames = append(cpioNames, name)
+	}
+	c.Check(cpioNames, DeepEquals, expected)
+}
+
+// TestOCIImageLayout tests that --oci produces a layout whose digests and diffID are correct.
+func (s *p2Integration) TestOCIImageLayout(c *C) {
+	layoutDir := c.MkDir()
+
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args:   []string{"--directory-mode", "-t", "tests/directory-mode/templates", "--oci", layoutDir},
+	}
+
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for directory mode with --oci != 0"))
+
+	readBlob := func(dgst digest.Digest) []byte {
+		content := MustReadFile(path.Join(layoutDir, "blobs", dgst.Algorithm().String(), dgst.Encoded()))
+		c.Assert(digest.FromBytes(content), Equals, dgst)
+		return content
+	}
+
+	index := ocispec.Index{}
+	c.Assert(json.Unmarshal(MustReadFile(path.Join(layoutDir, "index.json")), &index), IsNil)
+	c.Assert(index.Manifests, HasLen, 1)
+
+	manifest := ocispec.Manifest{}
+	c.Assert(json.Unmarshal(readBlob(index.Manifests[0].Digest), &manifest), IsNil)
+	c.Assert(manifest.Layers, HasLen, 1)
+	c.Check(manifest.Layers[0].MediaType, Equals, ocispec.MediaTypeImageLayerGzip)
+
+	config := ocispec.Image{}
+	c.Assert(json.Unmarshal(readBlob(manifest.Config.Digest), &config), IsNil)
+
+	layer := lo.Must(io.ReadAll(lo.Must(gzip.NewReader(bytes.NewReader(readBlob(manifest.Layers[0].Digest))))))
+	c.Check(config.RootFS.DiffIDs, DeepEquals, []digest.Digest{digest.FromBytes(layer)})
+}
+
+// TestOCIImageLayoutUpdate tests that writing into an existing layout succeeds and keeps the
+// manifests of other references in the index.
+func (s *p2Integration) TestOCIImageLayoutUpdate(c *C) {
+	layoutDir := c.MkDir()
+
+	run := func(ref string) {
+		entrypointArgs := entrypoint.LaunchArgs{
+			StdIn:  os.Stdin,
+			StdOut: os.Stdout,
+			StdErr: os.Stderr,
+			Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+			Args: []string{"--directory-mode", "-t", "tests/directory-mode/templates", "--oci", layoutDir,
+				"--oci-ref", ref, "--source-date-epoch", "0"},
+		}
+		exit := entrypoint.Entrypoint(entrypointArgs)
+		c.Assert(exit, Equals, 0, Commentf("Exit code for directory mode with --oci != 0"))
+	}
+	refs := func() []string {
+		index := ocispec.Index{}
+		c.Assert(json.Unmarshal(MustReadFile(path.Join(layoutDir, "index.json")), &index), IsNil)
+		return lo.Map(index.Manifests, func(desc ocispec.Descriptor, _ int) string {
+			return desc.Annotations[ocispec.AnnotationRefName]
+		})
+	}
+
+	run("v1")
+	run("v1")
+	c.Check(refs(), DeepEquals, []string{"v1"})
+
+	run("v2")
+	c.Check(refs(), DeepEquals, []string{"v1", "v2"})
+}
+
+func (s *p2Integration) TestParsingFilters(c *C) {
+	const templateFile string = "tests/data.parse.p2"
+	const emptyData string = "tests/data.parse.json"
+
+	const outputFile string = "tests/data.parse.test"
+	const expectedFile string = "tests/data.parse.out"
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args:   []string{"-t", templateFile, "-i", emptyData, "-o", outputFile},
+	}
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for input %s != 0", emptyData))
+	c.Check(string(MustReadFile(outputFile)), DeepEquals, string(MustReadFile(expectedFile)))
+
+	// from_json accepts trailing whitespace, but not a second value or other trailing data.
+	workDir := c.MkDir()
+	templatePath := path.Join(workDir, "template.p2")
+	dataPath := path.Join(workDir, "data.json")
+	c.Assert(os.WriteFile(templatePath, []byte("{{ value|from_json }}"), os.FileMode(0644)), IsNil)
+	for value, valid := range map[string]bool{"{\"a\": 1}\n ": true, "{\"a\": 1} x": false, "{\"a\": 1}{}": false, "1 2": false} {
+		c.Assert(os.WriteFile(dataPath, lo.Must(json.Marshal(map[string]string{"value": value})), os.FileMode(0644)), IsNil)
+		entrypointArgs.Args = []string{"-t", templatePath, "-i", dataPath, "-o", path.Join(workDir, "output")}
+		exit = entrypoint.Entrypoint(entrypointArgs)
+		c.Check(exit == 0, Equals, valid, Commentf("from_json input %q", value))
+	}
+}
+
+func (s *p2Integration) TestSerializationFilters(c *C) {
+	const templateFile string = "tests/data.serialization.p2"
+	const emptyData string = "tests/data.serialization.json"
+
+	const outputFile string = "tests/data.serialization.test"
+	const expectedFile string = "tests/data.serialization.out"
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args:   []string{"-t", templateFile, "-i", emptyData, "-o", outputFile},
+	}
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for input %s != 0", emptyData))
+	c.Check(string(MustReadFile(outputFile)), DeepEquals, string(MustReadFile(expectedFile)))
+}
+
+// TestSerializationFilterInvalidKeys tests that to_env, to_xml and to_ini reject keys which are
+// not valid variable, element, section or key names.
+func (s *p2Integration) TestSerializationFilterInvalidKeys(c *C) {
+	workDir := c.MkDir()
+	dataFile := path.Join(workDir, "data.json")
+
+	runData := func(filter string, data string) int {
+		templateFile := path.Join(workDir, "template.p2")
+		c.Assert(os.WriteFile(templateFile, []byte("{{ m|"+filter+" }}"), os.FileMode(0644)), IsNil)
+		c.Assert(os.WriteFile(dataFile, []byte(`{"m": `+data+`}`), os.FileMode(0644)), IsNil)
+		entrypointArgs := entrypoint.LaunchArgs{
+			StdIn:  os.Stdin,
+			StdOut: io.Discard,
+			StdErr: io.Discard,
+			Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+			Args:   []string{"-t", templateFile, "-i", dataFile},
+		}
+		return entrypoint.Entrypoint(entrypointArgs)
+	}
+	run := func(filter string, key string) int {
+		return runData(filter, fmt.Sprintf(`{%q: "v"}`, key))
+	}
+
+	c.Check(run("to_env", "valid_KEY1"), Equals, 0)
+	c.Check(run("to_xml", "valid-key.1"), Equals, 0)
+	c.Check(run("to_ini", "valid key.1"), Equals, 0)
+	for _, key := range []string{"bad key", "my-key", "1num", "x;rm -rf /"} {
+		c.Check(run("to_env", key), Not(Equals), 0, Commentf("to_env accepted key %q", key))
+	}
+	for _, key := range []string{"bad key", "1num", "a<b"} {
+		c.Check(run("to_xml", key), Not(Equals), 0, Commentf("to_xml accepted key %q", key))
+	}
+	for _, key := range []string{"a]b", "a=b", "a;b", "a#b", "a\nb"} {
+		c.Check(run("to_ini", key), Not(Equals), 0, Commentf("to_ini accepted key %q", key))
+		c.Check(runData("to_ini", fmt.Sprintf(`{"section": {%q: "v"}}`, key)), Not(Equals), 0,
+			Commentf("to_ini accepted section key %q", key))
+		c.Check(runData("to_ini", fmt.Sprintf(`{%q: {"key": "v"}}`, key)), Not(Equals), 0,
+			Commentf("to_ini accepted section name %q", key))
+	}
+
+	// Keys which only differ in case collide when upper-cased.
+	c.Check(runData("to_env:\"upper\"", `{"key": "1", "KEY": "2"}`), Not(Equals), 0)
+	c.Check(runData("to_env:\"upper\"", `{"key": "1", "other": "2"}`), Equals, 0)
+}
+
+func (s *p2Integration) TestRegexFilters(c *C) {
+	const templateFile string = "tests/data.regex.p2"
+	const emptyData string = "tests/data.regex.json"
+
+	const outputFile string = "tests/data.regex.test"
+	const expectedFile string = "tests/data.regex.out"
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args:   []string{"-t", templateFile, "-i", emptyData, "-o", outputFile},
+	}
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for input %s != 0", emptyData))
+	c.Check(string(MustReadFile(outputFile)), DeepEquals, string(MustReadFile(expectedFile)))
+}
+
+func (s *p2Integration) TestHashFilters(c *C) {
+	const templateFile string = "tests/data.hash.p2"
+	const emptyData string = "tests/data.hash.json"
+
+	const outputFile string = "tests/data.hash.test"
+	const expectedFile string = "tests/data.hash.out"
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args:   []string{"-t", templateFile, "-i", emptyData, "-o", outputFile},
+	}
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for input %s != 0", emptyData))
+	c.Check(string(MustReadFile(outputFile)), DeepEquals, string(MustReadFile(expectedFile)))
+}
+
+func (s *p2Integration) TestPasswordFilters(c *C) {
+	const templateFile string = "tests/data.password.p2"
+	const emptyData string = "tests/data.password.json"
+
+	const outputFile string = "tests/data.password.test"
+	const expectedFile string = "tests/data.password.out"
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args:   []string{"-t", templateFile, "-i", emptyData, "-o", outputFile},
+	}
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for input %s != 0", emptyData))
+	c.Check(string(MustReadFile(outputFile)), DeepEquals, string(MustReadFile(expectedFile)))
+}
+
+func (s *p2Integration) TestRandomFilters(c *C) {
+	const templateFile string = "tests/data.random.p2"
+	const emptyData string = "tests/data.random.json"
+
+	const outputFile string = "tests/data.random.test"
+	const expectedFile string = "tests/data.random.out"
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args:   []string{"-t", templateFile, "-i", emptyData, "-o", outputFile},
+	}
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for input %s != 0", emptyData))
+	c.Check(string(MustReadFile(outputFile)), DeepEquals, string(MustReadFile(expectedFile)))
+}
+
+func (s *p2Integration) TestRandomFiltersRequireSeed(c *C) {
+	const templateFile string = "tests/data.random-unseeded.p2"
+	const emptyData string = "tests/data.random.json"
+
+	outputFile := path.Join(c.MkDir(), "output")
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args:   []string{"-t", templateFile, "-i", emptyData, "-o", outputFile},
+	}
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Not(Equals), 0, Commentf("Unseeded generation succeeded without --allow-random"))
+
+	entrypointArgs.Args = append(entrypointArgs.Args, "--allow-random")
+	exit = entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for input %s != 0", emptyData))
+	c.Check(string(MustReadFile(outputFile)), Matches, "(?s)[0-9a-f-]{36}\n[A-Za-z0-9]{16}\n.*")
+}
+
+// TestRandomIntRange tests that random_int accepts the widest representable range and
+// rejects bounds which span every integer.
+func (s *p2Integration) TestRandomIntRange(c *C) {
+	workDir := c.MkDir()
+	dataFile := path.Join(workDir, "data.yml")
+	c.Assert(os.WriteFile(dataFile, []byte(fmt.Sprintf("widest: [%d, %d]\nfull: [%d, %d]\n",
+		math.MinInt64, math.MaxInt64-1, math.MinInt64, math.MaxInt64)), os.FileMode(0644)), IsNil)
+
+	run := func(bounds string) int {
+		templateFile := path.Join(workDir, bounds+".p2")
+		c.Assert(os.WriteFile(templateFile, []byte(`{{ "seed"|random_int:`+bounds+` }}`), os.FileMode(0644)), IsNil)
+		entrypointArgs := entrypoint.LaunchArgs{
+			StdIn:  os.Stdin,
+			StdOut: io.Discard,
+			StdErr: io.Discard,
+			Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+			Args:   []string{"-t", templateFile, "-i", dataFile},
+		}
+		return entrypoint.Entrypoint(entrypointArgs)
+	}
+
+	c.Check(run("widest"), Equals, 0)
+	c.Check(run("full"), Not(Equals), 0, Commentf("random_int accepted a range spanning every integer"))
+}
+
+func (s *p2Integration) TestDateFilters(c *C) {
+	const templateFile string = "tests/data.date.p2"
+	const emptyData string = "tests/data.date.json"
+
+	const outputFile string = "tests/data.date.test"
+	const expectedFile string = "tests/data.date.out"
+	env := lo.Must(envutil.FromEnvironment(os.Environ()))
+	// Pin the clock used by now()
+	env["SOURCE_DATE_EPOCH"] = "1234567890"
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    env,
+		Args:   []string{"-t", templateFile, "-i", emptyData, "-o", outputFile},
+	}
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for input %s != 0", emptyData))
+	c.Check(string(MustReadFile(outputFile)), DeepEquals, string(MustReadFile(expectedFile)))
+}
+
+func (s *p2Integration) TestDateFiltersDirectoryMode(c *C) {
+	templateDir := c.MkDir()
+	testOutputDir := c.MkDir()
+
+	c.Assert(os.WriteFile(path.Join(templateDir, "stamp"), []byte(
+		"{{ p2.OutputName }} {{ now()|unix_timestamp }}\n"), os.FileMode(0644)), IsNil)
+
+	env := lo.Must(envutil.FromEnvironment(os.Environ()))
+	env["SOURCE_DATE_EPOCH"] = "1234567890"
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    env,
+		Args:   []string{"--directory-mode", "-t", templateDir, "-o", testOutputDir},
+	}
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for directory mode != 0"))
+	c.Check(string(MustReadFile(path.Join(testOutputDir, "stamp"))), Equals, "stamp 1234567890\n")
+}
+
+func (s *p2Integration) TestNetworkFilters(c *C) {
+	const templateFile string = "tests/data.network.p2"
+	const emptyData string = "tests/data.network.json"
+
+	const outputFile string = "tests/data.network.test"
+	const expectedFile string = "tests/data.network.out"
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args:   []string{"-t", templateFile, "-i", emptyData, "-o", outputFile},
+	}
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for input %s != 0", emptyData))
+	c.Check(string(MustReadFile(outputFile)), DeepEquals, string(MustReadFile(expectedFile)))
+}
+
+func (s *p2Integration) TestSemverFilters(c *C) {
+	const templateFile string = "tests/data.semver.p2"
+	const emptyData string = "tests/data.semver.json"
+
+	const outputFile string = "tests/data.semver.test"
+	const expectedFile string = "tests/data.semver.out"
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args:   []string{"-t", templateFile, "-i", emptyData, "-o", outputFile},
+	}
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for input %s != 0", emptyData))
+	c.Check(string(MustReadFile(outputFile)), DeepEquals, string(MustReadFile(expectedFile)))
+}
+
+func (s *p2Integration) TestQuotingFilters(c *C) {
+	const templateFile string = "tests/data.quoting.p2"
+	const emptyData string = "tests/data.quoting.json"
+
+	const outputFile string = "tests/data.quoting.test"
+	const expectedFile string = "tests/data.quoting.out"
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args:   []string{"-t", templateFile, "-i", emptyData, "-o", outputFile},
+	}
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for input %s != 0", emptyData))
+	c.Check(string(MustReadFile(outputFile)), DeepEquals, string(MustReadFile(expectedFile)))
+
+	// Quoted output must not be HTML escaped again
+	entrypointArgs.Args = append(entrypointArgs.Args, "--autoescape")
+	exit = entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for input %s != 0", emptyData))
+	c.Check(string(MustReadFile(outputFile)), DeepEquals, string(MustReadFile(expectedFile)))
+}
+
+func (s *p2Integration) TestCollectionFilters(c *C) {
+	const templateFile string = "tests/data.collection.p2"
+	const emptyData string = "tests/data.collection.json"
+
+	const outputFile string = "tests/data.collection.test"
+	const expectedFile string = "tests/data.collection.out"
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args:   []string{"-t", templateFile, "-i", emptyData, "-o", outputFile},
+	}
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for input %s != 0", emptyData))
+	c.Check(string(MustReadFile(outputFile)), DeepEquals, string(MustReadFile(expectedFile)))
+}
+
+func (s *p2Integration) TestQueryFilter(c *C) {
+	const templateFile string = "tests/data.query.p2"
+	const emptyData string = "tests/data.query.yml"
+
+	const outputFile string = "tests/data.query.test"
+	const expectedFile string = "tests/data.query.out"
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args:   []string{"-t", templateFile, "-i", emptyData, "-o", outputFile},
+	}
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for input %s != 0", emptyData))
+	c.Check(string(MustReadFile(outputFile)), DeepEquals, string(MustReadFile(expectedFile)))
+}
+
+func (s *p2Integration) TestCaseFilters(c *C) {
+	const templateFile string = "tests/data.case.p2"
+	const emptyData string = "tests/data.case.json"
+
+	const outputFile string = "tests/data.case.test"
+	const expectedFile string = "tests/data.case.out"
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args:   []string{"-t", templateFile, "-i", emptyData, "-o", outputFile},
+	}
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for input %s != 0", emptyData))
+	c.Check(string(MustReadFile(outputFile)), DeepEquals, string(MustReadFile(expectedFile)))
+}
+
+func (s *p2Integration) TestPathFiltersDirectoryMode(c *C) {
+	templateDir := c.MkDir()
+	testOutputDir := c.MkDir()
+
+	c.Assert(os.MkdirAll(path.Join(templateDir, "etc/nginx"), os.FileMode(0777)), IsNil)
+	c.Assert(os.WriteFile(path.Join(templateDir, "etc/nginx/site.conf"), []byte(
+		`{{ p2.OutputDir|dirname|dirname|path_join:"var/www"|path_rel }}
+{{ "certs/site.pem"|path_abs == p2.OutputDir|path_join:"certs/site.pem" }}
+{{ "certs/site.pem"|path_rel:"../ssl" }}
+{{ p2.OutputPath|basename:".conf" }}
+`), os.FileMode(0644)), IsNil)
+
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args:   []string{"--directory-mode", "-t", templateDir, "-o", testOutputDir},
+	}
+
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for directory mode != 0"))
+	c.Check(string(MustReadFile(path.Join(testOutputDir, "etc/nginx/site.conf"))), Equals,
+		"../../var/www\nTrue\n../nginx/certs/site.pem\nsite\n")
+}
+
+func (s *p2Integration) TestPathFilters(c *C) {
+	const templateFile string = "tests/data.path.p2"
+	const emptyData string = "tests/data.path.json"
+
+	const outputFile string = "tests/data.path.test"
+	const expectedFile string = "tests/data.path.out"
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args:   []string{"-t", templateFile, "-i", emptyData, "-o", outputFile},
+	}
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for input %s != 0", emptyData))
+	c.Check(string(MustReadFile(outputFile)), DeepEquals, string(MustReadFile(expectedFile)))
+}
+
+func (s *p2Integration) TestCertificateFilters(c *C) {
+	const templateFile string = "tests/data.certificate.p2"
+	const emptyData string = "tests/data.certificate.yml"
+
+	const outputFile string = "tests/data.certificate.test"
+	const expectedFile string = "tests/data.certificate.out"
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args:   []string{"-t", templateFile, "-i", emptyData, "-o", outputFile},
+	}
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for input %s != 0", emptyData))
+	c.Check(string(MustReadFile(outputFile)), DeepEquals, string(MustReadFile(expectedFile)))
+
+	// A seeded certificate without not_before would change every day.
+	entrypointArgs.Args = []string{"-t", templateFile, "-i", "tests/data.certificate-undated.yml",
+		"-o", path.Join(c.MkDir(), "output")}
+	exit = entrypoint.Entrypoint(entrypointArgs)
+	c.Check(exit, Not(Equals), 0, Commentf("Seeded certificate without not_before was accepted"))
+}
+
+func (s *p2Integration) TestKeyFilters(c *C) {
+	const templateFile string = "tests/data.key.p2"
+	const emptyData string = "tests/data.key.yml"
+
+	const outputFile string = "tests/data.key.test"
+	const expectedFile string = "tests/data.key.out"
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args:   []string{"-t", templateFile, "-i", emptyData, "-o", outputFile},
+	}
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for input %s != 0", emptyData))
+	c.Check(string(MustReadFile(outputFile)), DeepEquals, string(MustReadFile(expectedFile)))
+}
+
+func (s *p2Integration) TestEncryptedInput(c *C) {
+	const templateFile string = "tests/data.encrypted.p2"
+	expectedOutput := MustReadFile("tests/data.encrypted.out")
+
+	testDatas := []string{
+		"tests/data.encrypted.sops.yml",
+		"tests/data.encrypted.sops.json",
+		"tests/data.encrypted.sops.env",
+		"tests/data.encrypted.age.yml",
+	}
+
+	env := lo.Must(envutil.FromEnvironment(os.Environ()))
+	env[entrypoint.EnvSopsAgeKeyFile] = "tests/data.encrypted.agekey"
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    env,
+		Args:   []string{},
+	}
+
+	for _, td := range testDatas {
+		outputFile := path.Join(c.MkDir(), "output")
+		entrypointArgs.Args = []string{"-t", templateFile, "-i", td, "-o", outputFile}
+		exit := entrypoint.Entrypoint(entrypointArgs)
+		c.Check(exit, Equals, 0, Commentf("Exit code for input %s != 0", td))
+		c.Check(MustReadFile(outputFile), DeepEquals, expectedOutput, Commentf("Output for input %s", td))
+	}
+
+	// Keys can also be supplied directly in the environment.
+	delete(entrypointArgs.Env, entrypoint.EnvSopsAgeKeyFile)
+	entrypointArgs.Env[entrypoint.EnvSopsAgeKey] = string(MustReadFile("tests/data.encrypted.agekey"))
+	outputFile := path.Join(c.MkDir(), "output")
+	entrypointArgs.Args = []string{"-t", templateFile, "-i", "tests/data.encrypted.sops.yml", "-o", outputFile}
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Check(exit, Equals, 0)
+	c.Check(MustReadFile(outputFile), DeepEquals, expectedOutput)
+
+	// A modified file fails MAC verification.
+	entrypointArgs.Args = []string{"-t", templateFile, "-i", "tests/data.encrypted-tampered.sops.yml", "-o", outputFile}
+	exit = entrypoint.Entrypoint(entrypointArgs)
+	c.Check(exit, Not(Equals), 0, Commentf("Tampered input was accepted"))
+
+	// Encrypted input cannot be read without a key.
+	delete(entrypointArgs.Env, entrypoint.EnvSopsAgeKey)
+	entrypointArgs.Env["XDG_CONFIG_HOME"] = c.MkDir()
+	entrypointArgs.Args = []string{"-t", templateFile, "-i", "tests/data.encrypted.sops.yml", "-o", outputFile}
+	exit = entrypoint.Entrypoint(entrypointArgs)
+	c.Check(exit, Not(Equals), 0, Commentf("Encrypted input was read without a key"))
+}
+
+func (s *p2Integration) TestEnvUnterminatedQuote(c *C) {
+	// A stray quote in a plain env file is an error, and does not swallow the following lines.
+	dataFile := path.Join(c.MkDir(), "data.env")
+	c.Assert(os.WriteFile(dataFile, []byte("quoted=\"unterminated\nsimple=\"value\n"), os.FileMode(0644)), IsNil)
+
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: os.Stderr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args:   []string{"-t", "tests/data.p2", "-i", dataFile, "-o", path.Join(c.MkDir(), "output")},
+	}
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Check(exit, Not(Equals), 0, Commentf("Unterminated quote was accepted"))
+}
+
+func (s *p2Integration) TestDebugOutputIsRedacted(c *C) {
+	const templateFile string = "tests/data.redact.p2"
+	const dataFile string = "tests/data.redact.yml"
+
+	stdErr := new(bytes.Buffer)
+	outputFile := path.Join(c.MkDir(), "output")
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: os.Stdout,
+		StdErr: stdErr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args: []string{"-t", templateFile, "-i", dataFile, "-o", outputFile, "--debug",
+			"--redact-schema", "tests/data.redact-schema.yml", "--redact-key", "db.host"},
+	}
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for input %s != 0", dataFile))
+	for _, secret := range []string{"db.local", "hunter2secret", "tok-12345", "98765"} {
+		c.Check(strings.Contains(stdErr.String(), secret), Equals, false, Commentf("%s was not redacted", secret))
+	}
+	c.Check(stdErr.String(), Matches, `(?s).*"name": "alice".*`)
+	// Template output is unaffected.
+	c.Check(string(MustReadFile(outputFile)), Equals, "db.local hunter2secret 98765\n")
+
+	stdErr.Reset()
+	entrypointArgs.Args = []string{"-t", templateFile, "-i", dataFile, "-o", outputFile, "--debug", "--no-redact"}
+	exit = entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for input %s != 0", dataFile))
+	c.Check(stdErr.String(), Matches, "(?s).*hunter2secret.*")
+}
+
+// TestDebugDumpSensitiveRootKey tests that the dump masks every value when the input root key
+// is itself sensitive.
+func (s *p2Integration) TestDebugDumpSensitiveRootKey(c *C) {
+	const dataFile string = "tests/data.yml"
+
+	templateFile := path.Join(c.MkDir(), "template.p2")
+	c.Assert(os.WriteFile(templateFile, []byte("{{ secrets.simple_value1 }}"), os.FileMode(0644)), IsNil)
+
+	stdErr := new(bytes.Buffer)
+	stdOut := new(bytes.Buffer)
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: stdOut,
+		StdErr: stdErr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args:   []string{"-t", templateFile, "-i", dataFile, "--input-root-key", "secrets", "--debug"},
+	}
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for input %s != 0", dataFile))
+	c.Check(stdOut.String(), Equals, "a value")
+
+	dump := entrypoint.InputDump{}
+	c.Assert(json.Unmarshal(stdErr.Bytes(), &dump), IsNil)
+	c.Assert(dump.Keys, HasLen, 2)
+	for _, entry := range dump.Keys {
+		c.Check(entry.RootKey, Equals, "secrets")
+		c.Check(entry.Value, Equals, redact.Mask, Commentf("%s was not redacted", entry.Key))
+	}
+}
+
+func (s *p2Integration) TestDebugDumpFormats(c *C) {
+	const templateFile string = "tests/data.p2"
+	const dataFile string = "tests/data.yml"
+
+	stdErr := new(bytes.Buffer)
+	env := lo.Must(envutil.FromEnvironment(os.Environ()))
+	env["P2_TEST_DUMP_KEY"] = "from env"
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: io.Discard,
+		StdErr: stdErr,
+		Env:    env,
+		Args:   []string{"-t", templateFile, "-i", dataFile, "--debug", "--include-env", "--input-root-key", "cfg"},
+	}
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for input %s != 0", dataFile))
+
+	dump := entrypoint.InputDump{}
+	c.Assert(json.Unmarshal(stdErr.Bytes(), &dump), IsNil)
+	sources := make(map[string]string)
+	for _, entry := range dump.Keys {
+		c.Check(entry.RootKey, Equals, "cfg")
+		sources[entry.Key] = entry.Source
+	}
+	c.Check(sources["simple_value1"], Equals, "file:"+dataFile)
+	c.Check(sources["P2_TEST_DUMP_KEY"], Equals, entrypoint.InputSourceEnv)
+
+	stdErr.Reset()
+	entrypointArgs.Args = []string{"-t", templateFile, "-i", dataFile, "--debug", "--dump-format", "yaml"}
+	exit = entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for input %s != 0", dataFile))
+	c.Check(stdErr.String(), Equals, "keys:\n- key: simple_value1\n  source: file:tests/data.yml\n  value: a value\n"+
+		"- key: simple_value2\n  source: file:tests/data.yml\n  value: a value\n")
+
+	stdErr.Reset()
+	entrypointArgs.Args = []string{"-t", templateFile, "-i", dataFile, "--debug", "--dump-format", "table"}
+	exit = entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Equals, 0, Commentf("Exit code for input %s != 0", dataFile))
+	c.Check(stdErr.String(), Equals, "KEY            SOURCE               VALUE\n"+
+		"simple_value1  file:tests/data.yml  \"a value\"\n"+
+		"simple_value2  file:tests/data.yml  \"a value\"\n")
+}
+
+func (s *p2Integration) TestTemplateErrorSnippets(c *C) {
+	const templateFile string = "tests/data.template-error.p2"
+	const dataFile string = "tests/data.yml"
+
+	stdErr := new(bytes.Buffer)
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: io.Discard,
+		StdErr: stdErr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args:   []string{"-t", templateFile, "-i", dataFile},
+	}
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Not(Equals), 0, Commentf("Exit code for failing template %s == 0", templateFile))
+	c.Check(stdErr.String(), Equals, templateFile+":2:20: error: filter:date: filter input argument must be of type 'time.Time'\n"+
+		" 1 | value:\n"+
+		" 2 |   {{ simple_value1|date:\"2006\" }}\n"+
+		"   |                    ^~~~\n")
+}
+
+func (s *p2Integration) TestDirectoryModeReportsAllTemplateErrors(c *C) {
+	const templateDir string = "tests/template-errors/templates"
+	outputDir := c.MkDir()
+
+	stdErr := new(bytes.Buffer)
+	entrypointArgs := entrypoint.LaunchArgs{
+		StdIn:  os.Stdin,
+		StdOut: io.Discard,
+		StdErr: stdErr,
+		Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+		Args:   []string{"--directory-mode", "-t", templateDir, "-i", "tests/data.yml", "-o", outputDir},
+	}
+	exit := entrypoint.Entrypoint(entrypointArgs)
+	c.Assert(exit, Not(Equals), 0, Commentf("Exit code for failing templates in %s == 0", templateDir))
+	c.Check(stdErr.String(), Equals, templateDir+"/bad1.p2:2:19: error: parser: Filter 'nofilter' does not exist.\n"+
+		" 1 | first line\n"+
+		" 2 | \t{{ simple_value1|nofilter }}\n"+
+		"   | \t                 ^~~~~~~~\n"+
+		templateDir+"/sub/bad2.p2:1:23: error: parser: Unexpected EOF, expected tag elif or else or endif.\n"+
+		" 1 | {% if simple_value1 %}\n"+
+		"   |                       ^\n")
+
+	// Nothing is rendered when any template fails to load.
+	_, err := os.Stat(path.Join(outputDir, "good.p2"))
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *p2Integration) TestEnvParseErrorIsRedacted(c *C) {
+	// The redaction schema is not loaded yet when env input is parsed, so values are masked
+	// whatever their key.
+	for _, line := range []string{"hunter2secret", "setting=hunter2secret extra", "setting=\"hunter2secret"} {
+		dataFile := path.Join(c.MkDir(), "data.env")
+		c.Assert(os.WriteFile(dataFile, []byte(line+"\n"), os.FileMode(0644)), IsNil)
+
+		stdErr := new(bytes.Buffer)
+		entrypointArgs := entrypoint.LaunchArgs{
+			StdIn:  os.Stdin,
+			StdOut: os.Stdout,
+			StdErr: stdErr,
+			Env:    lo.Must(envutil.FromEnvironment(os.Environ())),
+			Args:   []string{"-t", "tests/data.p2", "-i", dataFile, "-o", path.Join(c.MkDir(), "output")},
+		}
+		exit := entrypoint.Entrypoint(entrypointArgs)
+		c.Check(exit, Not(Equals), 0, Commentf("Invalid line %q was accepted", line))
+		c.Check(strings.Contains(stdErr.String(), "hunter2secret"), Equals, false, Commentf("%q was not redacted", line))
+	}
+}
@@ -0,0 +1,226 @@
+package entrypoint
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/pkg/errors"
+	"github.com/wrouesnel/p2cli/pkg/fileconsts"
+	"github.com/wrouesnel/p2cli/pkg/templating"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// ManifestKindTemplate marks a manifest entry produced by rendering a template.
+	ManifestKindTemplate = "template"
+	// ManifestKindWriteFile marks a manifest entry produced by the write_file filter.
+	ManifestKindWriteFile = "write_file"
+)
+
+// ManifestEntry describes a single output produced by p2.
+type ManifestEntry struct {
+	Kind     string `json:"kind"           yaml:"kind"`
+	Template string `json:"template"       yaml:"template"`
+	Path     string `json:"path"           yaml:"path"`
+	Size     int64  `json:"size"           yaml:"size"`
+	SHA256   string `json:"sha256"         yaml:"sha256"`
+	Mode     string `json:"mode,omitempty" yaml:"mode,omitempty"`
+	UID      *int   `json:"uid,omitempty"  yaml:"uid,omitempty"`
+	GID      *int   `json:"gid,omitempty"  yaml:"gid,omitempty"`
+}
+
+// Manifest is the machine-readable record of every output produced by a p2 run.
+type Manifest struct {
+	Outputs []*ManifestEntry `json:"outputs" yaml:"outputs"`
+}
+
+// outputManifest accumulates manifest entries while templates are executed.
+type outputManifest struct {
+	manifest      Manifest
+	filterSet     *templating.FilterSet
+	templatePaths map[string]string
+	// entryName maps an output path to the path recorded for it, such as its archive member
+	// name. Output paths are recorded unchanged if it is nil.
+	entryName func(outputPath string) (string, error)
+	restore   func()
+}
+
+func newOutputManifest(filterSet *templating.FilterSet, templatePaths map[string]string) *outputManifest {
+	return &outputManifest{
+		manifest:      Manifest{Outputs: []*ManifestEntry{}},
+		filterSet:     filterSet,
+		templatePaths: templatePaths,
+	}
+}
+
+// countingWriter counts and hashes the bytes written through it.
+type countingWriter struct {
+	size int64
+	hash hash.Hash
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.size += int64(len(p))
+	return cw.hash.Write(p) //nolint:wrapcheck
+}
+
+func formatMode(mode os.FileMode) string {
+	return fmt.Sprintf("%04o", uint32(mode.Perm()))
+}
+
+// wrapPrepareOutput wraps a TemplateEngine PrepareOutput function so that the content of each
+// output is measured, and the Chown/Chmod operations of the FilterSet are recorded. onDisk
+// indicates the output is a real file whose final mode can be read back after it is written.
+func (m *outputManifest) wrapPrepareOutput(onDisk bool,
+	prepareOutput func(inputData pongo2.Context, outputPath string) (io.Writer, func() error, error),
+) func(inputData pongo2.Context, outputPath string) (io.Writer, func() error, error) {
+	return func(inputData pongo2.Context, outputPath string) (io.Writer, func() error, error) {
+		// Undo the recording hooks of the previous output before the inner function runs, since
+		// it may install its own.
+		if m.restore != nil {
+			m.restore()
+			m.restore = nil
+		}
+
+		writer, finalizer, err := prepareOutput(inputData, outputPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		entry := &ManifestEntry{
+			Kind:     ManifestKindTemplate,
+			Template: m.templatePaths[outputPath],
+			Path:     outputPath,
+		}
+		if m.entryName != nil {
+			if entry.Path, err = m.entryName(outputPath); err != nil {
+				return nil, nil, err
+			}
+		}
+		if entry.Path == "" {
+			entry.Path = templating.StdOutVal
+		}
+
+		chown := m.filterSet.Chown
+		chmod := m.filterSet.Chmod
+		m.filterSet.Chown = func(name string, uid, gid int) error {
+			if err := chown(name, uid, gid); err != nil {
+				return err
+			}
+			if uid != -1 {
+				entry.UID = &uid
+			}
+			if gid != -1 {
+				entry.GID = &gid
+			}
+			return nil
+		}
+		m.filterSet.Chmod = func(name string, mode os.FileMode) error {
+			if err := chmod(name, mode); err != nil {
+				return err
+			}
+			entry.Mode = formatMode(mode)
+			return nil
+		}
+		m.restore = func() {
+			m.filterSet.Chown = chown
+			m.filterSet.Chmod = chmod
+		}
+
+		counter := &countingWriter{hash: sha256.New()}
+
+		wrappedFinalizer := func() error {
+			if finalizer != nil {
+				if err := finalizer(); err != nil {
+					return err
+				}
+			}
+
+			entry.Size = counter.size
+			entry.SHA256 = hex.EncodeToString(counter.hash.Sum(nil))
+			if entry.Mode == "" && onDisk {
+				if st, err := os.Stat(outputPath); err == nil {
+					entry.Mode = formatMode(st.Mode())
+				}
+			}
+			m.manifest.Outputs = append(m.manifest.Outputs, entry)
+			return nil
+		}
+
+		return io.MultiWriter(writer, counter), wrappedFinalizer, nil
+	}
+}
+
+// wrapWriteFile wraps the write_file filter so files it creates are added to the manifest.
+func (m *outputManifest) wrapWriteFile(filterFunc pongo2.FilterFunction) pongo2.FilterFunction {
+	return func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+		out, perr := filterFunc(in, param)
+		if perr != nil {
+			return out, perr
+		}
+
+		// write_file resolves its path against the current working directory, which is
+		// the output directory of the template in directory mode.
+		outputPath, err := filepath.Abs(param.String())
+		if err != nil {
+			outputPath = param.String()
+		}
+
+		content := []byte(in.String())
+		sum := sha256.Sum256(content)
+		entry := &ManifestEntry{
+			Kind:     ManifestKindWriteFile,
+			Template: m.templatePaths[m.filterSet.OutputFileName],
+			Path:     outputPath,
+			Size:     int64(len(content)),
+			SHA256:   hex.EncodeToString(sum[:]),
+		}
+		if st, err := os.Stat(outputPath); err == nil {
+			entry.Mode = formatMode(st.Mode())
+		}
+		m.manifest.Outputs = append(m.manifest.Outputs, entry)
+
+		return out, nil
+	}
+}
+
+// write serializes the manifest to the given path. format may be "json", "yaml" or "auto",
+// in which case it is inferred from the file extension.
+func (m *outputManifest) write(manifestPath string, format string) error {
+	sort.SliceStable(m.manifest.Outputs, func(i, j int) bool {
+		return m.manifest.Outputs[i].Path < m.manifest.Outputs[j].Path
+	})
+
+	if format == FormatAuto {
+		format = "json"
+		if ext := strings.ToLower(filepath.Ext(manifestPath)); ext == ".yml" || ext == ".yaml" {
+			format = "yaml"
+		}
+	}
+
+	var data []byte
+	var err error
+	switch format {
+	case "yaml", "yml":
+		data, err = yaml.Marshal(&m.manifest)
+	default:
+		data, err = json.MarshalIndent(&m.manifest, "", "  ")
+	}
+	if err != nil {
+		return errors.Wrap(err, "manifest: serialization failed")
+	}
+
+	if err := os.WriteFile(manifestPath, data, os.FileMode(fileconsts.OS_ALL_RW)); err != nil {
+		return errors.Wrap(err, "manifest: write failed")
+	}
+	return nil
+}
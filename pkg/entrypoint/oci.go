@@ -0,0 +1,235 @@
+package entrypoint
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/wrouesnel/p2cli/pkg/fileconsts"
+)
+
+// DefaultOCIPlatform is the platform recorded in OCI image outputs unless overridden.
+const DefaultOCIPlatform = "linux/amd64"
+
+// ociLayoutWriter receives an uncompressed tar stream and writes it as the single layer of
+// an image in an OCI image layout directory. The diffID is computed over the uncompressed
+// stream and the layer digest over the compressed blob.
+type ociLayoutWriter struct {
+	layoutDir   string
+	ref         string
+	platform    ocispec.Platform
+	created     time.Time
+	compression string
+
+	diffID     hash.Hash
+	blob       *bytes.Buffer
+	compressor io.WriteCloser
+}
+
+// parseOCIPlatform parses a platform string of the form os/arch[/variant].
+func parseOCIPlatform(platform string) (ocispec.Platform, error) {
+	parts := strings.Split(platform, "/")
+	//nolint:mnd
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return ocispec.Platform{}, fmt.Errorf("platform must be of the form os/arch[/variant]: %s", platform)
+	}
+
+	result := ocispec.Platform{OS: parts[0], Architecture: parts[1]}
+	//nolint:mnd
+	if len(parts) == 3 {
+		result.Variant = parts[2]
+	}
+	return result, nil
+}
+
+func newOCILayoutWriter(layoutDir string, ref string, platform string, created time.Time,
+	compression string,
+) (*ociLayoutWriter, error) {
+	parsedPlatform, err := parseOCIPlatform(platform)
+	if err != nil {
+		return nil, err
+	}
+
+	if compression == CompressionAuto {
+		compression = CompressionGzip
+	}
+
+	blob := new(bytes.Buffer)
+	compressor, err := newCompressor(blob, compression)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ociLayoutWriter{
+		layoutDir:   layoutDir,
+		ref:         ref,
+		platform:    parsedPlatform,
+		created:     created,
+		compression: compression,
+		diffID:      sha256.New(),
+		blob:        blob,
+		compressor:  compressor,
+	}, nil
+}
+
+func (o *ociLayoutWriter) Write(p []byte) (int, error) {
+	_, _ = o.diffID.Write(p)
+	return o.compressor.Write(p) //nolint:wrapcheck
+}
+
+func (o *ociLayoutWriter) layerMediaType() string {
+	switch o.compression {
+	case CompressionGzip:
+		return ocispec.MediaTypeImageLayerGzip
+	case CompressionZstd:
+		return ocispec.MediaTypeImageLayerZstd
+	default:
+		return ocispec.MediaTypeImageLayer
+	}
+}
+
+// writeBlob writes content into the blob store of the layout and returns its descriptor.
+func (o *ociLayoutWriter) writeBlob(mediaType string, content []byte) (ocispec.Descriptor, error) {
+	dgst := digest.FromBytes(content)
+	blobDir := filepath.Join(o.layoutDir, ocispec.ImageBlobsDir, dgst.Algorithm().String())
+	if err := os.MkdirAll(blobDir, os.FileMode(fileconsts.OS_ALL_RWX)); err != nil {
+		return ocispec.Descriptor{}, errors.Wrap(err, "oci: could not create blob directory")
+	}
+	// Blobs are content addressed, so an existing blob from a previous run already holds this
+	// content. Blobs are read-only and cannot be rewritten by non-root users.
+	blobPath := filepath.Join(blobDir, dgst.Encoded())
+	if _, err := os.Stat(blobPath); os.IsNotExist(err) {
+		if err := os.WriteFile(blobPath, content, os.FileMode(fileconsts.OS_ALL_R)); err != nil {
+			return ocispec.Descriptor{}, errors.Wrap(err, "oci: could not write blob")
+		}
+	} else if err != nil {
+		return ocispec.Descriptor{}, errors.Wrap(err, "oci: could not stat blob")
+	}
+	return ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    dgst,
+		Size:      int64(len(content)),
+	}, nil
+}
+
+func (o *ociLayoutWriter) writeJSONBlob(mediaType string, v interface{}) (ocispec.Descriptor, error) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return ocispec.Descriptor{}, errors.Wrap(err, "oci: could not serialize "+mediaType)
+	}
+	return o.writeBlob(mediaType, content)
+}
+
+// Close finishes the layer and writes the blobs, manifest, index and layout marker.
+func (o *ociLayoutWriter) Close() error {
+	if err := o.compressor.Close(); err != nil {
+		return errors.Wrap(err, "oci: could not finish layer compression")
+	}
+
+	layerDesc, err := o.writeBlob(o.layerMediaType(), o.blob.Bytes())
+	if err != nil {
+		return err
+	}
+
+	diffID := digest.NewDigest(digest.SHA256, o.diffID)
+	created := o.created
+
+	config := ocispec.Image{
+		Created:  &created,
+		Platform: o.platform,
+		RootFS: ocispec.RootFS{
+			Type:    "layers",
+			DiffIDs: []digest.Digest{diffID},
+		},
+		History: []ocispec.History{{
+			Created:   &created,
+			CreatedBy: "p2",
+		}},
+	}
+	configDesc, err := o.writeJSONBlob(ocispec.MediaTypeImageConfig, config)
+	if err != nil {
+		return err
+	}
+
+	manifest := ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2}, //nolint:mnd
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    configDesc,
+		Layers:    []ocispec.Descriptor{layerDesc},
+	}
+	manifestDesc, err := o.writeJSONBlob(ocispec.MediaTypeImageManifest, manifest)
+	if err != nil {
+		return err
+	}
+	manifestDesc.Platform = &o.platform
+	if o.ref != "" {
+		manifestDesc.Annotations = map[string]string{ocispec.AnnotationRefName: o.ref}
+	}
+
+	index, err := o.readIndex()
+	if err != nil {
+		return err
+	}
+	index.Manifests = mergeIndexManifests(index.Manifests, manifestDesc)
+	indexContent, err := json.Marshal(index)
+	if err != nil {
+		return errors.Wrap(err, "oci: could not serialize index")
+	}
+	if err := os.WriteFile(filepath.Join(o.layoutDir, ocispec.ImageIndexFile), indexContent, os.FileMode(fileconsts.OS_ALL_RW)); err != nil {
+		return errors.Wrap(err, "oci: could not write index")
+	}
+
+	layoutContent, err := json.Marshal(ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion})
+	if err != nil {
+		return errors.Wrap(err, "oci: could not serialize layout marker")
+	}
+	if err := os.WriteFile(filepath.Join(o.layoutDir, ocispec.ImageLayoutFile), layoutContent, os.FileMode(fileconsts.OS_ALL_RW)); err != nil {
+		return errors.Wrap(err, "oci: could not write layout marker")
+	}
+
+	return nil
+}
+
+// readIndex loads the index of an existing layout, or returns an empty index.
+func (o *ociLayoutWriter) readIndex() (*ocispec.Index, error) {
+	index := &ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2}, //nolint:mnd
+		MediaType: ocispec.MediaTypeImageIndex,
+	}
+	content, err := os.ReadFile(filepath.Join(o.layoutDir, ocispec.ImageIndexFile))
+	if os.IsNotExist(err) {
+		return index, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "oci: could not read existing index")
+	}
+	if err := json.Unmarshal(content, index); err != nil {
+		return nil, errors.Wrap(err, "oci: existing index is corrupt")
+	}
+	return index, nil
+}
+
+// mergeIndexManifests adds desc to the manifests of an existing index, replacing the manifest
+// with the same reference name and keeping all others. Without a reference name only an
+// unnamed manifest with the same digest is replaced.
+func mergeIndexManifests(manifests []ocispec.Descriptor, desc ocispec.Descriptor) []ocispec.Descriptor {
+	ref := desc.Annotations[ocispec.AnnotationRefName]
+	merged := make([]ocispec.Descriptor, 0, len(manifests)+1)
+	for _, existing := range manifests {
+		if existing.Annotations[ocispec.AnnotationRefName] == ref && (ref != "" || existing.Digest == desc.Digest) {
+			continue
+		}
+		merged = append(merged, existing)
+	}
+	return append(merged, desc)
+}
@@ -0,0 +1,132 @@
+package entrypoint
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/pkg/errors"
+	"github.com/wrouesnel/p2cli/pkg/fileconsts"
+	"go.uber.org/zap"
+)
+
+// DefaultPruneStateFile is the name of the state file written to the output directory
+// when --prune is used.
+const DefaultPruneStateFile = ".p2-state.json"
+
+// pruneState is the on-disk record of the files a directory mode run generated. Paths are
+// stored relative to the output directory so the tree can be moved between runs.
+type pruneState struct {
+	Files []string `json:"files"`
+}
+
+// readPruneState loads the state file. A missing state file is not an error - it simply
+// means no previous run recorded anything, so nothing is eligible for pruning.
+func readPruneState(statePath string) (*pruneState, error) {
+	state := &pruneState{Files: []string{}}
+
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, errors.Wrap(err, "readPruneState")
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, errors.Wrap(err, "readPruneState: state file is corrupt")
+	}
+	return state, nil
+}
+
+// writePruneState records the supplied output paths (relative to rootDir) into the state file.
+// Paths outside rootDir are never pruned, so they are not recorded.
+func writePruneState(statePath string, rootDir string, outputPaths []string) error {
+	state := pruneState{Files: make([]string, 0, len(outputPaths))}
+	for _, outputPath := range outputPaths {
+		relPath, err := filepath.Rel(rootDir, outputPath)
+		if err != nil {
+			return errors.Wrap(err, "writePruneState")
+		}
+		if isParentRel(relPath) {
+			continue
+		}
+		state.Files = append(state.Files, filepath.ToSlash(relPath))
+	}
+	sort.Strings(state.Files)
+	state.Files = slices.Compact(state.Files)
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "writePruneState")
+	}
+
+	if err := os.WriteFile(statePath, data, os.FileMode(fileconsts.OS_ALL_RW)); err != nil {
+		return errors.Wrap(err, "writePruneState")
+	}
+	return nil
+}
+
+// pruneOutputs deletes files which were recorded in the previous state file but which were
+// not generated by the current run. Files never recorded by p2 are left untouched.
+func pruneOutputs(statePath string, rootDir string, outputPaths []string) error {
+	logger := zap.L()
+
+	previous, err := readPruneState(statePath)
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]struct{}, len(outputPaths))
+	for _, outputPath := range outputPaths {
+		current[filepath.Clean(outputPath)] = struct{}{}
+	}
+
+	for _, relPath := range previous.Files {
+		stalePath := filepath.Join(rootDir, filepath.FromSlash(relPath))
+
+		// Refuse to follow entries which escape the output directory.
+		if rel, err := filepath.Rel(rootDir, stalePath); err != nil || isParentRel(rel) {
+			logger.Warn("Ignoring state file entry outside of the output directory", zap.String("path", relPath))
+			continue
+		}
+
+		if _, found := current[stalePath]; found {
+			continue
+		}
+
+		logger.Info("Pruning stale output file", zap.String("path", stalePath))
+		if err := os.Remove(stalePath); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "pruneOutputs")
+		}
+	}
+
+	return writePruneState(statePath, rootDir, outputPaths)
+}
+
+// isParentRel returns true if the relative path rel leaves its base directory.
+func isParentRel(rel string) bool {
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// recordWriteFile wraps the write_file filter so files it creates are appended to written
+// and recorded in the --prune state file alongside template outputs.
+func recordWriteFile(filterFunc pongo2.FilterFunction, written *[]string) pongo2.FilterFunction {
+	return func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+		out, perr := filterFunc(in, param)
+		if perr != nil {
+			return out, perr
+		}
+
+		// write_file resolves its path against the current working directory, which is
+		// the output directory of the template in directory mode.
+		if outputPath, err := filepath.Abs(param.String()); err == nil {
+			*written = append(*written, outputPath)
+		}
+		return out, nil
+	}
+}
@@ -0,0 +1,307 @@
+// Package redact masks sensitive input values in debug output and logs. Values are sensitive
+// when their key matches a pattern, when a schema marks their path, or when a template passes
+// them through the secret filter.
+package redact
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap/zapcore"
+)
+
+// Mask replaces sensitive values.
+const Mask = "[REDACTED]"
+
+// minValueLength is the shortest value masked wherever it appears in log text. Shorter values
+// such as "1" or "yes" would mask unrelated text, so they are only masked by key.
+const minValueLength = 4
+
+// pathWildcard matches any key or list index in a schema path.
+const pathWildcard = "*"
+
+// DefaultKeyPatterns are the key patterns treated as sensitive unless redaction is disabled.
+//
+//nolint:gochecknoglobals
+var DefaultKeyPatterns = []string{
+	"*password*", "*passwd*", "*secret*", "*token*", "*api_key*", "*apikey*",
+	"*private_key*", "*credential*", "sops_age_key*",
+}
+
+// Redactor records which input keys are sensitive and the secret values found under them. It
+// is safe for concurrent use.
+type Redactor struct {
+	mu       sync.RWMutex
+	patterns []string
+	paths    [][]string
+	values   map[string]struct{}
+	replacer *strings.Replacer
+}
+
+// New returns a Redactor for the given key patterns. Patterns are case insensitive globs
+// matched against key names, or against the dotted path from the root of the input when the
+// pattern contains a dot.
+func New(patterns []string) (*Redactor, error) {
+	r := &Redactor{values: make(map[string]struct{})}
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.Wrapf(err, "invalid redaction pattern %q", pattern)
+		}
+		r.patterns = append(r.patterns, pattern)
+	}
+	return r, nil
+}
+
+// AddSchema marks the paths a JSON Schema declares as sensitive: those with "writeOnly": true,
+// "format": "password" or "x-sensitive": true. Only properties, additionalProperties and items
+// are followed.
+func (r *Redactor) AddSchema(schema interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addSchema(schema, nil)
+}
+
+func (r *Redactor) addSchema(schema interface{}, keyPath []string) {
+	node := stringMap(schema)
+	if node == nil {
+		return
+	}
+	if node["writeOnly"] == true || node["format"] == "password" || node["x-sensitive"] == true {
+		r.paths = append(r.paths, append([]string{}, keyPath...))
+	}
+	for key, sub := range stringMap(node["properties"]) {
+		r.addSchema(sub, append(keyPath, key))
+	}
+	r.addSchema(node["additionalProperties"], append(keyPath, pathWildcard))
+	r.addSchema(node["items"], append(keyPath, pathWildcard))
+}
+
+// IsSensitive returns true if the value at the given key path is sensitive.
+func (r *Redactor) IsSensitive(keyPath []string) bool {
+	if len(keyPath) == 0 {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	name := strings.ToLower(keyPath[len(keyPath)-1])
+	dotted := strings.ToLower(strings.Join(keyPath, "."))
+	for _, pattern := range r.patterns {
+		subject := name
+		if strings.Contains(pattern, ".") {
+			subject = dotted
+		}
+		if matched, _ := path.Match(pattern, subject); matched {
+			return true
+		}
+	}
+	for _, schemaPath := range r.paths {
+		if matchPath(schemaPath, keyPath) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPath(schemaPath []string, keyPath []string) bool {
+	if len(schemaPath) != len(keyPath) {
+		return false
+	}
+	for idx, element := range schemaPath {
+		if element != pathWildcard && element != keyPath[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+// AddValue records a secret value to be masked wherever it appears in log text.
+func (r *Redactor) AddValue(value interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addValue(value)
+}
+
+func (r *Redactor) addValue(value interface{}) {
+	switch value := value.(type) {
+	case nil:
+	case map[string]interface{}:
+		for _, v := range value {
+			r.addValue(v)
+		}
+	case map[interface{}]interface{}:
+		for _, v := range value {
+			r.addValue(v)
+		}
+	case []interface{}:
+		for _, v := range value {
+			r.addValue(v)
+		}
+	default:
+		text := fmt.Sprint(value)
+		if len(text) >= minValueLength {
+			r.values[text] = struct{}{}
+			r.replacer = nil
+		}
+	}
+}
+
+// Scan records the values of every sensitive key in the input data.
+func (r *Redactor) Scan(data interface{}) {
+	r.walk(data, nil, func(keyPath []string, value interface{}) interface{} {
+		r.AddValue(value)
+		return value
+	})
+}
+
+// Data returns a copy of the input data with the values of sensitive keys masked.
+func (r *Redactor) Data(data interface{}) interface{} {
+	return r.DataAt(nil, data)
+}
+
+// DataAt is Data for a value found at keyPath in the input data. The whole value is masked
+// if a key above it is sensitive.
+func (r *Redactor) DataAt(keyPath []string, data interface{}) interface{} {
+	for idx := 1; idx < len(keyPath); idx++ {
+		if r.IsSensitive(keyPath[:idx]) {
+			return Mask
+		}
+	}
+	return r.walk(data, keyPath, func([]string, interface{}) interface{} {
+		return Mask
+	})
+}
+
+// walk copies data, replacing the value of each sensitive key with the result of onSensitive.
+func (r *Redactor) walk(data interface{}, keyPath []string, onSensitive func([]string, interface{}) interface{}) interface{} {
+	if r.IsSensitive(keyPath) {
+		return onSensitive(keyPath, data)
+	}
+	switch data := data.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(data))
+		for key, value := range data {
+			result[key] = r.walk(value, append(keyPath, key), onSensitive)
+		}
+		return result
+	case map[interface{}]interface{}:
+		result := make(map[interface{}]interface{}, len(data))
+		for key, value := range data {
+			result[key] = r.walk(value, append(keyPath, fmt.Sprint(key)), onSensitive)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(data))
+		for idx, value := range data {
+			result[idx] = r.walk(value, append(keyPath, strconv.Itoa(idx)), onSensitive)
+		}
+		return result
+	default:
+		return data
+	}
+}
+
+// String masks every recorded secret value in text.
+func (r *Redactor) String(text string) string {
+	r.mu.RLock()
+	replacer := r.replacer
+	empty := len(r.values) == 0
+	r.mu.RUnlock()
+	if empty {
+		return text
+	}
+
+	if replacer == nil {
+		r.mu.Lock()
+		// Replace longer values first so a secret containing another is masked whole.
+		values := make([]string, 0, len(r.values))
+		for value := range r.values {
+			values = append(values, value)
+		}
+		sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+		pairs := make([]string, 0, 2*len(values)) //nolint:mnd
+		for _, value := range values {
+			pairs = append(pairs, value, Mask)
+		}
+		replacer = strings.NewReplacer(pairs...)
+		r.replacer = replacer
+		r.mu.Unlock()
+	}
+	return replacer.Replace(text)
+}
+
+func stringMap(value interface{}) map[string]interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return value
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			result[fmt.Sprint(k)] = v
+		}
+		return result
+	default:
+		return nil
+	}
+}
+
+// Core wraps a zap core so recorded secret values are masked in log messages and in string,
+// stringer and error fields.
+func (r *Redactor) Core(core zapcore.Core) zapcore.Core {
+	return &redactingCore{Core: core, redactor: r}
+}
+
+type redactingCore struct {
+	zapcore.Core
+	redactor *Redactor
+}
+
+func (c *redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactingCore{Core: c.Core.With(c.redactor.fields(fields)), redactor: c.redactor}
+}
+
+func (c *redactingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *redactingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = c.redactor.String(entry.Message)
+	return c.Core.Write(entry, c.redactor.fields(fields)) //nolint:wrapcheck
+}
+
+// fields masks secret values in log fields. Error fields which contain a secret are replaced
+// by their masked message, which drops the verbose form with its stack trace.
+func (r *Redactor) fields(fields []zapcore.Field) []zapcore.Field {
+	result := make([]zapcore.Field, len(fields))
+	for idx, field := range fields {
+		result[idx] = field
+		var text string
+		switch field.Type { //nolint:exhaustive
+		case zapcore.StringType:
+			text = field.String
+		case zapcore.StringerType:
+			if stringer, ok := field.Interface.(fmt.Stringer); ok {
+				text = stringer.String()
+			}
+		case zapcore.ErrorType:
+			if err, ok := field.Interface.(error); ok {
+				text = err.Error()
+			}
+		default:
+			continue
+		}
+		if masked := r.String(text); masked != text || field.Type == zapcore.StringerType {
+			result[idx] = zapcore.Field{Key: field.Key, Type: zapcore.StringType, String: masked}
+		}
+	}
+	return result
+}
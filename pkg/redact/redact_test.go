@@ -0,0 +1,105 @@
+package redact_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/wrouesnel/p2cli/pkg/redact"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type testSuite struct{}
+
+var _ = Suite(&testSuite{})
+
+func (s *testSuite) TestKeyPatterns(c *C) {
+	redactor, err := redact.New(append(redact.DefaultKeyPatterns, "db.host"))
+	c.Assert(err, IsNil)
+	c.Check(redactor.IsSensitive([]string{"DB_PASSWORD"}), Equals, true)
+	c.Check(redactor.IsSensitive([]string{"github", "Token"}), Equals, true)
+	c.Check(redactor.IsSensitive([]string{"db", "host"}), Equals, true)
+	c.Check(redactor.IsSensitive([]string{"web", "host"}), Equals, false)
+	c.Check(redactor.IsSensitive([]string{"username"}), Equals, false)
+
+	_, err = redact.New([]string{"["})
+	c.Check(err, NotNil)
+}
+
+func (s *testSuite) TestSchema(c *C) {
+	redactor, err := redact.New(nil)
+	c.Assert(err, IsNil)
+	redactor.AddSchema(map[string]interface{}{
+		"properties": map[string]interface{}{
+			"users": map[string]interface{}{
+				"items": map[string]interface{}{
+					"properties": map[string]interface{}{
+						"pin": map[string]interface{}{"writeOnly": true},
+					},
+				},
+			},
+			"keys": map[string]interface{}{
+				"additionalProperties": map[string]interface{}{"format": "password"},
+			},
+		},
+	})
+	c.Check(redactor.IsSensitive([]string{"users", "3", "pin"}), Equals, true)
+	c.Check(redactor.IsSensitive([]string{"users", "3", "name"}), Equals, false)
+	c.Check(redactor.IsSensitive([]string{"keys", "deploy"}), Equals, true)
+}
+
+func (s *testSuite) TestData(c *C) {
+	redactor, err := redact.New(redact.DefaultKeyPatterns)
+	c.Assert(err, IsNil)
+	data := map[string]interface{}{
+		"user":     "admin",
+		"password": "hunter2",
+		"nested":   map[interface{}]interface{}{"api_token": []interface{}{"a", "b"}},
+	}
+	c.Check(redactor.Data(data), DeepEquals, map[string]interface{}{
+		"user":     "admin",
+		"password": redact.Mask,
+		"nested":   map[interface{}]interface{}{"api_token": redact.Mask},
+	})
+	// The input is not modified.
+	c.Check(data["password"], Equals, "hunter2")
+
+	redactor.Scan(data)
+	c.Check(redactor.String("login admin:hunter2"), Equals, "login admin:"+redact.Mask)
+}
+
+func (s *testSuite) TestDataAt(c *C) {
+	redactor, err := redact.New(redact.DefaultKeyPatterns)
+	c.Assert(err, IsNil)
+	c.Check(redactor.DataAt([]string{"db"}, map[string]interface{}{"password": "x", "host": "h"}),
+		DeepEquals, map[string]interface{}{"password": redact.Mask, "host": "h"})
+	c.Check(redactor.DataAt([]string{"db", "password"}, "x"), Equals, redact.Mask)
+	// Values below a sensitive key are masked whole.
+	c.Check(redactor.DataAt([]string{"secrets", "db"}, map[string]interface{}{"host": "h"}), Equals, redact.Mask)
+}
+
+func (s *testSuite) TestLogCore(c *C) {
+	redactor, err := redact.New(nil)
+	c.Assert(err, IsNil)
+	redactor.AddValue("hunter2")
+	redactor.AddValue("1")
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(redactor.Core(core)).With(zap.String("context", "pw=hunter2"))
+	logger.Error("failed with hunter2", zap.Error(errors.New("bad password hunter2")), zap.Int("count", 1))
+
+	entries := logs.AllUntimed()
+	c.Assert(entries, HasLen, 1)
+	c.Check(entries[0].Message, Equals, "failed with "+redact.Mask)
+	c.Check(entries[0].ContextMap(), DeepEquals, map[string]interface{}{
+		"context": "pw=" + redact.Mask,
+		"error":   "bad password " + redact.Mask,
+		"count":   int64(1),
+	})
+}
@@ -0,0 +1,229 @@
+package templating
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/flosch/pongo2/v6"
+	"golang.org/x/text/unicode/norm"
+)
+
+// splitWords splits an identifier or phrase into words. Words are separated by any character
+// which is not a letter or digit, and at case changes: "fooBar" splits before "B", and an
+// acronym such as "HTTPServer" splits before its final upper case letter. Digits stay with
+// the preceding word.
+func splitWords(value string) []string {
+	var words []string
+	runes := []rune(value)
+	start := -1
+	for idx, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if start >= 0 {
+				words = append(words, string(runes[start:idx]))
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = idx
+			continue
+		}
+
+		prev := runes[idx-1]
+		boundary := false
+		switch {
+		case unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)):
+			boundary = true
+		case unicode.IsUpper(r) && unicode.IsUpper(prev) && idx+1 < len(runes) && unicode.IsLower(runes[idx+1]):
+			boundary = true
+		}
+		if boundary {
+			words = append(words, string(runes[start:idx]))
+			start = idx
+		}
+	}
+	if start >= 0 {
+		words = append(words, string(runes[start:]))
+	}
+	return words
+}
+
+// isAcronym returns true for words of more than one letter which are entirely upper case.
+func isAcronym(word string) bool {
+	letters := 0
+	for _, r := range word {
+		if unicode.IsLower(r) {
+			return false
+		}
+		if unicode.IsLetter(r) {
+			letters++
+		}
+	}
+	return letters > 1
+}
+
+// isMixedCase returns true if value contains both upper and lower case letters. Acronyms are
+// only recognised in mixed case input, since every word of SCREAMING_SNAKE_CASE input such as
+// "DATABASE_URL" would otherwise be an acronym.
+func isMixedCase(value string) bool {
+	upper, lower := false, false
+	for _, r := range value {
+		upper = upper || unicode.IsUpper(r)
+		lower = lower || unicode.IsLower(r)
+	}
+	return upper && lower
+}
+
+// capitalize upper cases the first letter of a word and lower cases the rest, leaving
+// acronyms intact if keepAcronyms is set.
+func capitalize(word string, keepAcronyms bool) string {
+	if keepAcronyms && isAcronym(word) {
+		return word
+	}
+	first, size := utf8.DecodeRuneInString(word)
+	return string(unicode.ToTitle(first)) + strings.ToLower(word[size:])
+}
+
+// caseInput splits the input of a case filter into words, and reports whether acronyms
+// should be kept intact.
+func caseInput(sender string, in *pongo2.Value) ([]string, bool, *pongo2.Error) {
+	if !in.IsString() {
+		return nil, false, &pongo2.Error{
+			Sender:    sender,
+			OrigError: FilterError{Reason: "filter input must be of type 'string'."},
+		}
+	}
+	return splitWords(in.String()), isMixedCase(in.String()), nil
+}
+
+func joinWords(words []string, separator string, transform func(idx int, word string) string) string {
+	result := make([]string, len(words))
+	for idx, word := range words {
+		result[idx] = transform(idx, word)
+	}
+	return strings.Join(result, separator)
+}
+
+// FilterSnakeCase converts its input to snake_case.
+func (fs *FilterSet) FilterSnakeCase(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	words, _, perr := caseInput("filter:snake_case", in)
+	if perr != nil {
+		return nil, perr
+	}
+	return pongo2.AsValue(joinWords(words, "_", func(_ int, word string) string {
+		return strings.ToLower(word)
+	})), nil
+}
+
+// FilterScreamingSnake converts its input to SCREAMING_SNAKE_CASE, such as for environment
+// variable names.
+func (fs *FilterSet) FilterScreamingSnake(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	words, _, perr := caseInput("filter:screaming_snake", in)
+	if perr != nil {
+		return nil, perr
+	}
+	return pongo2.AsValue(joinWords(words, "_", func(_ int, word string) string {
+		return strings.ToUpper(word)
+	})), nil
+}
+
+// FilterKebabCase converts its input to kebab-case.
+func (fs *FilterSet) FilterKebabCase(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	words, _, perr := caseInput("filter:kebab_case", in)
+	if perr != nil {
+		return nil, perr
+	}
+	return pongo2.AsValue(joinWords(words, "-", func(_ int, word string) string {
+		return strings.ToLower(word)
+	})), nil
+}
+
+// FilterCamelCase converts its input to camelCase. Acronyms after the first word of mixed case
+// input are kept in upper case.
+func (fs *FilterSet) FilterCamelCase(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	words, keepAcronyms, perr := caseInput("filter:camel_case", in)
+	if perr != nil {
+		return nil, perr
+	}
+	return pongo2.AsValue(joinWords(words, "", func(idx int, word string) string {
+		if idx == 0 {
+			return strings.ToLower(word)
+		}
+		return capitalize(word, keepAcronyms)
+	})), nil
+}
+
+// FilterPascalCase converts its input to PascalCase, keeping acronyms of mixed case input in
+// upper case.
+func (fs *FilterSet) FilterPascalCase(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	words, keepAcronyms, perr := caseInput("filter:pascal_case", in)
+	if perr != nil {
+		return nil, perr
+	}
+	return pongo2.AsValue(joinWords(words, "", func(_ int, word string) string {
+		return capitalize(word, keepAcronyms)
+	})), nil
+}
+
+// FilterTitleWords splits its input into capitalized words separated by spaces, keeping
+// acronyms of mixed case input in upper case.
+func (fs *FilterSet) FilterTitleWords(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	words, keepAcronyms, perr := caseInput("filter:title_words", in)
+	if perr != nil {
+		return nil, perr
+	}
+	return pongo2.AsValue(joinWords(words, " ", func(_ int, word string) string {
+		return capitalize(word, keepAcronyms)
+	})), nil
+}
+
+// slugTransliterations replaces letters which Unicode does not decompose to ASCII.
+//
+//nolint:gochecknoglobals
+var slugTransliterations = strings.NewReplacer(
+	"ß", "ss", "æ", "ae", "Æ", "AE", "ø", "o", "Ø", "O", "œ", "oe", "Œ", "OE",
+	"đ", "d", "Đ", "D", "ł", "l", "Ł", "L", "þ", "th", "Þ", "TH",
+)
+
+// FilterSlugify converts its input to a lower case slug of ASCII letters and digits separated
+// by hyphens, suitable for URLs and Kubernetes resource names. Words are split as for
+// kebab_case and accents are removed from letters. An optional param limits the length, such
+// as 63 for a DNS label.
+func (fs *FilterSet) FilterSlugify(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	if !in.IsString() {
+		return nil, &pongo2.Error{
+			Sender:    "filter:slugify",
+			OrigError: FilterError{Reason: "filter input must be of type 'string'."},
+		}
+	}
+	maxLength := -1
+	if !param.IsNil() {
+		length, perr := integerParam("filter:slugify", param, "maximum length")
+		if perr != nil {
+			return nil, perr
+		}
+		maxLength = int(length)
+	}
+
+	// Decompose accented letters so the combining marks can be dropped, and treat any other
+	// character outside ASCII as a separator.
+	ascii := new(strings.Builder)
+	for _, r := range norm.NFKD.String(slugTransliterations.Replace(in.String())) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+		case r < utf8.RuneSelf:
+			ascii.WriteRune(r)
+		default:
+			ascii.WriteByte(' ')
+		}
+	}
+
+	result := joinWords(splitWords(ascii.String()), "-", func(_ int, word string) string {
+		return strings.ToLower(word)
+	})
+	if maxLength >= 0 && len(result) > maxLength {
+		result = strings.TrimRight(result[:maxLength], "-")
+	}
+	return pongo2.AsValue(result), nil
+}
@@ -0,0 +1,375 @@
+package templating
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/flosch/pongo2/v6"
+)
+
+const (
+	certKeyTypeECDSA   = "ecdsa"
+	certKeyTypeEd25519 = "ed25519"
+
+	certDefaultCADays   = 3650
+	certDefaultLeafDays = 365
+	certSerialBytes     = 16
+)
+
+var (
+	errNoCertificate = errors.New("no CERTIFICATE block found in input")
+	errNoPrivateKey  = errors.New("no private key found in CA")
+)
+
+// certSpec holds the certificate generation parameters read from a filter input map.
+type certSpec struct {
+	Seed         string
+	CommonName   string
+	Organization string
+	DNSNames     []string
+	IPAddresses  []net.IP
+	Days         int
+	NotBefore    time.Time
+	KeyType      string
+	Usages       []x509.ExtKeyUsage
+}
+
+// fingerprint formats a digest as colon separated upper case hex, as openssl does.
+func fingerprint(digest []byte) string {
+	parts := make([]string, len(digest))
+	for idx, b := range digest {
+		parts[idx] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+func stringList(v interface{}) []string {
+	if s, ok := v.(string); ok {
+		return []string{s}
+	}
+	elems, _ := asSlice(v)
+	result := make([]string, 0, len(elems))
+	for _, elem := range elems {
+		result = append(result, scalarString(elem))
+	}
+	return result
+}
+
+// parseCertSpec reads certificate parameters from a map. Recognized keys are seed,
+// common_name, organization, dns_names, ip_addresses, days, not_before, key_type ("ecdsa" or
+// "ed25519") and usages (a list of "server" and "client").
+//
+//nolint:cyclop
+func (fs *FilterSet) parseCertSpec(sender string, in *pongo2.Value, defaultDays int) (*certSpec, *pongo2.Error) {
+	m, ok := asStringMap(in.Interface())
+	if !ok {
+		return nil, &pongo2.Error{
+			Sender:    sender,
+			OrigError: FilterError{Reason: "filter input must be a map of certificate parameters."},
+		}
+	}
+
+	spec := &certSpec{
+		Seed:         scalarString(m["seed"]),
+		CommonName:   scalarString(m["common_name"]),
+		Organization: scalarString(m["organization"]),
+		DNSNames:     stringList(m["dns_names"]),
+		Days:         defaultDays,
+		KeyType:      certKeyTypeECDSA,
+	}
+	if spec.CommonName == "" {
+		return nil, &pongo2.Error{
+			Sender:    sender,
+			OrigError: FilterError{Reason: "common_name is required."},
+		}
+	}
+
+	for _, value := range stringList(m["ip_addresses"]) {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, &pongo2.Error{
+				Sender:    sender,
+				OrigError: FilterError{Reason: fmt.Sprintf("invalid IP address %q", value)},
+			}
+		}
+		spec.IPAddresses = append(spec.IPAddresses, ip)
+	}
+
+	if days, ok := m["days"]; ok {
+		value, perr := integerParam(sender, pongo2.AsValue(days), "days")
+		if perr != nil {
+			return nil, perr
+		}
+		spec.Days = int(value)
+	}
+
+	// Without a seed the validity period starts at the beginning of the current day. A seeded
+	// certificate must not change from day to day, so it needs not_before or a pinned clock.
+	if notBefore, ok := m["not_before"]; ok {
+		t, perr := asTime(sender, pongo2.AsValue(notBefore))
+		if perr != nil {
+			return nil, perr
+		}
+		spec.NotBefore = t.UTC()
+	} else if spec.Seed != "" && fs.Now == nil {
+		return nil, &pongo2.Error{
+			Sender:    sender,
+			OrigError: FilterError{Reason: "not_before is required with a seed unless SOURCE_DATE_EPOCH is set."},
+		}
+	} else {
+		spec.NotBefore = fs.CurrentTime().UTC().Truncate(24 * time.Hour) //nolint:mnd
+	}
+
+	if keyType, ok := m["key_type"]; ok {
+		spec.KeyType = scalarString(keyType)
+	}
+	if spec.KeyType != certKeyTypeECDSA && spec.KeyType != certKeyTypeEd25519 {
+		return nil, &pongo2.Error{
+			Sender:    sender,
+			OrigError: FilterError{Reason: "key_type must be 'ecdsa' or 'ed25519'."},
+		}
+	}
+
+	usages := []string{"server", "client"}
+	if value, ok := m["usages"]; ok {
+		usages = stringList(value)
+	}
+	for _, usage := range usages {
+		switch usage {
+		case "server":
+			spec.Usages = append(spec.Usages, x509.ExtKeyUsageServerAuth)
+		case "client":
+			spec.Usages = append(spec.Usages, x509.ExtKeyUsageClientAuth)
+		default:
+			return nil, &pongo2.Error{
+				Sender:    sender,
+				OrigError: FilterError{Reason: "usages must be 'server' or 'client'."},
+			}
+		}
+	}
+	return spec, nil
+}
+
+// generateKey derives a private key from the random source. ECDSA keys use P-256.
+func generateKey(source io.Reader, keyType string) (crypto.Signer, error) {
+	if keyType == certKeyTypeEd25519 {
+		seed := make([]byte, ed25519.SeedSize)
+		if _, err := io.ReadFull(source, seed); err != nil {
+			return nil, err
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+
+	// Draw scalars until one lies within the curve order, which almost always succeeds first.
+	scalar := make([]byte, 32) //nolint:mnd
+	for {
+		if _, err := io.ReadFull(source, scalar); err != nil {
+			return nil, err
+		}
+		if key, err := ecdsa.ParseRawPrivateKey(elliptic.P256(), scalar); err == nil {
+			return key, nil
+		}
+	}
+}
+
+func randomSerial(source io.Reader) (*big.Int, error) {
+	b := make([]byte, certSerialBytes)
+	if _, err := io.ReadFull(source, b); err != nil {
+		return nil, err
+	}
+	// Clear the top bit so the serial is a positive DER integer.
+	b[0] &= 0x7f
+	return new(big.Int).SetBytes(b), nil
+}
+
+// createCertificate generates a key and certificate for a spec. A nil parent creates a
+// self-signed certificate. The key and serial are derived from the seed together with the
+// common name and SANs, so certificates for different names never share a key. Certificates
+// are signed without randomness (RFC 6979 for ECDSA) so the output depends only on the inputs.
+func (fs *FilterSet) createCertificate(sender string, spec *certSpec, template *x509.Certificate,
+	parent *x509.Certificate, parentKey crypto.Signer) (map[string]interface{}, *pongo2.Error) {
+	ips := make([]string, 0, len(spec.IPAddresses))
+	for _, ip := range spec.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	source, _, perr := fs.randomSource(sender, pongo2.AsValue(spec.Seed),
+		spec.CommonName, strings.Join(spec.DNSNames, ","), strings.Join(ips, ","))
+	if perr != nil {
+		return nil, perr
+	}
+
+	key, err := generateKey(source, spec.KeyType)
+	if err != nil {
+		return nil, &pongo2.Error{Sender: sender, OrigError: err}
+	}
+	serial, err := randomSerial(source)
+	if err != nil {
+		return nil, &pongo2.Error{Sender: sender, OrigError: err}
+	}
+
+	template.SerialNumber = serial
+	template.Subject = pkix.Name{CommonName: spec.CommonName}
+	if spec.Organization != "" {
+		template.Subject.Organization = []string{spec.Organization}
+	}
+	template.NotBefore = spec.NotBefore
+	template.NotAfter = spec.NotBefore.AddDate(0, 0, spec.Days)
+	template.DNSNames = spec.DNSNames
+	template.IPAddresses = spec.IPAddresses
+
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(nil, template, parent, key.Public(), parentKey)
+	if err != nil {
+		return nil, &pongo2.Error{Sender: sender, OrigError: err}
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, &pongo2.Error{Sender: sender, OrigError: err}
+	}
+
+	return map[string]interface{}{
+		"cert": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		"key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})),
+	}, nil
+}
+
+// parseCertificatePEM returns the first certificate in PEM input.
+func parseCertificatePEM(data []byte) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errNoCertificate
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// parseCA reads the certificate and private key of a CA map as returned by tls_ca.
+func parseCA(sender string, param *pongo2.Value) (*x509.Certificate, crypto.Signer, *pongo2.Error) {
+	m, ok := asStringMap(param.Interface())
+	if !ok {
+		return nil, nil, &pongo2.Error{
+			Sender:    sender,
+			OrigError: FilterError{Reason: "filter param must be a CA map with 'cert' and 'key' PEM values."},
+		}
+	}
+
+	cert, err := parseCertificatePEM([]byte(scalarString(m["cert"])))
+	if err != nil {
+		return nil, nil, &pongo2.Error{Sender: sender, OrigError: err}
+	}
+	block, _ := pem.Decode([]byte(scalarString(m["key"])))
+	if block == nil {
+		return nil, nil, &pongo2.Error{Sender: sender, OrigError: errNoPrivateKey}
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, &pongo2.Error{Sender: sender, OrigError: err}
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, &pongo2.Error{Sender: sender, OrigError: errNoPrivateKey}
+	}
+	return cert, signer, nil
+}
+
+// FilterX509Parse parses the first certificate in PEM input into a map of subject,
+// common_name, issuer, serial, not_before, not_after, dns_names, ip_addresses,
+// email_addresses, is_ca, and sha256 and sha1 fingerprints.
+func (fs *FilterSet) FilterX509Parse(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	data, perr := parseFilterInput("filter:x509_parse", in)
+	if perr != nil {
+		return nil, perr
+	}
+	cert, err := parseCertificatePEM(data)
+	if err != nil {
+		return nil, &pongo2.Error{Sender: "filter:x509_parse", OrigError: err}
+	}
+
+	ips := make([]string, 0, len(cert.IPAddresses))
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	sha256Sum := sha256.Sum256(cert.Raw)
+	sha1Sum := sha1.Sum(cert.Raw) //nolint:gosec
+
+	return pongo2.AsValue(map[string]interface{}{
+		"subject":            cert.Subject.String(),
+		"common_name":        cert.Subject.CommonName,
+		"issuer":             cert.Issuer.String(),
+		"serial":             cert.SerialNumber.Text(16), //nolint:mnd
+		"not_before":         cert.NotBefore,
+		"not_after":          cert.NotAfter,
+		"dns_names":          cert.DNSNames,
+		"ip_addresses":       ips,
+		"email_addresses":    cert.EmailAddresses,
+		"is_ca":              cert.IsCA,
+		"fingerprint_sha256": fingerprint(sha256Sum[:]),
+		"fingerprint_sha1":   fingerprint(sha1Sum[:]),
+	}), nil
+}
+
+// FilterTLSCA generates a self-signed CA certificate and key from a map of parameters, returning
+// a map of "cert" and "key" PEM strings. The same seed gives the same key and serial number.
+func (fs *FilterSet) FilterTLSCA(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	spec, perr := fs.parseCertSpec("filter:tls_ca", in, certDefaultCADays)
+	if perr != nil {
+		return nil, perr
+	}
+
+	template := &x509.Certificate{
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+	}
+	result, perr := fs.createCertificate("filter:tls_ca", spec, template, nil, nil)
+	if perr != nil {
+		return nil, perr
+	}
+	return pongo2.AsValue(result), nil
+}
+
+// FilterTLSCert generates a leaf certificate and key signed by the CA given as the param, which
+// is a map of "cert" and "key" PEM strings such as returned by tls_ca. The result is a map of
+// "cert", "key" and "chain" (the certificate followed by the CA certificate).
+func (fs *FilterSet) FilterTLSCert(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	spec, perr := fs.parseCertSpec("filter:tls_cert", in, certDefaultLeafDays)
+	if perr != nil {
+		return nil, perr
+	}
+	caCert, caKey, perr := parseCA("filter:tls_cert", param)
+	if perr != nil {
+		return nil, perr
+	}
+
+	template := &x509.Certificate{
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           spec.Usages,
+	}
+	result, perr := fs.createCertificate("filter:tls_cert", spec, template, caCert, caKey)
+	if perr != nil {
+		return nil, perr
+	}
+	result["chain"] = result["cert"].(string) + string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}))
+	return pongo2.AsValue(result), nil
+}
@@ -0,0 +1,348 @@
+package templating
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/flosch/pongo2/v6"
+)
+
+func collectionInputMap(sender string, in *pongo2.Value) (map[string]interface{}, *pongo2.Error) {
+	m, ok := asStringMap(in.Interface())
+	if !ok {
+		return nil, &pongo2.Error{
+			Sender:    sender,
+			OrigError: FilterError{Reason: "filter input must be a map."},
+		}
+	}
+	return m, nil
+}
+
+func collectionInputSlice(sender string, in *pongo2.Value) ([]interface{}, *pongo2.Error) {
+	s, ok := asSlice(in.Interface())
+	if !ok {
+		return nil, &pongo2.Error{
+			Sender:    sender,
+			OrigError: FilterError{Reason: "filter input must be a list."},
+		}
+	}
+	return s, nil
+}
+
+// keyParams returns the key names given as a single string or as a set or list of strings.
+func keyParams(sender string, param *pongo2.Value) ([]string, *pongo2.Error) {
+	switch {
+	case param.IsString():
+		return []string{param.String()}, nil
+	case param.CanSlice():
+		keys := make([]string, 0, param.Len())
+		for idx := 0; idx < param.Len(); idx++ {
+			keys = append(keys, param.Index(idx).String())
+		}
+		return keys, nil
+	default:
+		return nil, &pongo2.Error{
+			Sender:    sender,
+			OrigError: FilterError{Reason: "filter param must be a key name, or a set of key names."},
+		}
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// deepMerge returns a new map with the keys of override merged into base. Nested maps are
+// merged recursively, and any other value in override replaces the value in base.
+func deepMerge(base map[string]interface{}, override map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(base)+len(override))
+	for k, v := range base {
+		result[k] = v
+	}
+	for k, v := range override {
+		overrideMap, overrideIsMap := asStringMap(v)
+		baseMap, baseIsMap := asStringMap(result[k])
+		if overrideIsMap && baseIsMap {
+			result[k] = deepMerge(baseMap, overrideMap)
+			continue
+		}
+		result[k] = v
+	}
+	return result
+}
+
+// attribute looks up a dotted attribute path such as "meta.name" in a map element.
+func attribute(v interface{}, path string) (interface{}, bool) {
+	for _, part := range strings.Split(path, ".") {
+		m, ok := asStringMap(v)
+		if !ok {
+			return nil, false
+		}
+		v, ok = m[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// compareValues orders numbers numerically, and all other values by their string form.
+// Missing values sort first.
+func compareValues(a interface{}, b interface{}) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+	aValue, bValue := pongo2.AsValue(a), pongo2.AsValue(b)
+	if aValue.IsNumber() && bValue.IsNumber() {
+		switch {
+		case aValue.Float() < bValue.Float():
+			return -1
+		case aValue.Float() > bValue.Float():
+			return 1
+		default:
+			return 0
+		}
+	}
+	return strings.Compare(scalarString(a), scalarString(b))
+}
+
+// FilterMerge deep merges the param map into the input map, returning a new map. Nested maps
+// are merged and any other value from the param replaces the input's value.
+func (fs *FilterSet) FilterMerge(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	base, perr := collectionInputMap("filter:merge", in)
+	if perr != nil {
+		return nil, perr
+	}
+	override, ok := asStringMap(param.Interface())
+	if !ok {
+		return nil, &pongo2.Error{
+			Sender:    "filter:merge",
+			OrigError: FilterError{Reason: "filter param must be a map."},
+		}
+	}
+	return pongo2.AsValue(deepMerge(base, override)), nil
+}
+
+// FilterPick returns a map containing only the given keys of the input map.
+func (fs *FilterSet) FilterPick(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	m, perr := collectionInputMap("filter:pick", in)
+	if perr != nil {
+		return nil, perr
+	}
+	keys, perr := keyParams("filter:pick", param)
+	if perr != nil {
+		return nil, perr
+	}
+
+	result := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		if v, ok := m[key]; ok {
+			result[key] = v
+		}
+	}
+	return pongo2.AsValue(result), nil
+}
+
+// FilterOmit returns a map containing all except the given keys of the input map.
+func (fs *FilterSet) FilterOmit(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	m, perr := collectionInputMap("filter:omit", in)
+	if perr != nil {
+		return nil, perr
+	}
+	keys, perr := keyParams("filter:omit", param)
+	if perr != nil {
+		return nil, perr
+	}
+
+	result := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		result[k] = v
+	}
+	for _, key := range keys {
+		delete(result, key)
+	}
+	return pongo2.AsValue(result), nil
+}
+
+// FilterDictKeys returns the sorted keys of the input map.
+func (fs *FilterSet) FilterDictKeys(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	m, perr := collectionInputMap("filter:dict_keys", in)
+	if perr != nil {
+		return nil, perr
+	}
+	return pongo2.AsValue(sortedKeys(m)), nil
+}
+
+// FilterDictValues returns the values of the input map, ordered by their sorted keys.
+func (fs *FilterSet) FilterDictValues(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	m, perr := collectionInputMap("filter:dict_values", in)
+	if perr != nil {
+		return nil, perr
+	}
+	values := make([]interface{}, 0, len(m))
+	for _, key := range sortedKeys(m) {
+		values = append(values, m[key])
+	}
+	return pongo2.AsValue(values), nil
+}
+
+func flattenList(s []interface{}, depth int, result []interface{}) []interface{} {
+	for _, elem := range s {
+		if nested, ok := asSlice(elem); ok && depth != 0 {
+			result = flattenList(nested, depth-1, result)
+			continue
+		}
+		result = append(result, elem)
+	}
+	return result
+}
+
+// FilterFlatten flattens nested lists into a single list. An optional param limits the number
+// of levels flattened.
+func (fs *FilterSet) FilterFlatten(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	s, perr := collectionInputSlice("filter:flatten", in)
+	if perr != nil {
+		return nil, perr
+	}
+
+	depth := -1
+	if !param.IsNil() {
+		levels, perr := integerParam("filter:flatten", param, "depth")
+		if perr != nil {
+			return nil, perr
+		}
+		depth = int(levels)
+	}
+	return pongo2.AsValue(flattenList(s, depth, []interface{}{})), nil
+}
+
+// FilterUnique removes duplicate elements from a list, keeping the first occurrence.
+func (fs *FilterSet) FilterUnique(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	s, perr := collectionInputSlice("filter:unique", in)
+	if perr != nil {
+		return nil, perr
+	}
+
+	seen := make(map[string]struct{}, len(s))
+	result := make([]interface{}, 0, len(s))
+	for _, elem := range s {
+		// fmt prints maps with sorted keys, so equal values always give the same key.
+		key := fmt.Sprintf("%T:%v", elem, elem)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		result = append(result, elem)
+	}
+	return pongo2.AsValue(result), nil
+}
+
+// FilterGroupBy groups a list of maps by the value of an attribute, returning a map of
+// attribute values to lists of elements. Elements without the attribute are omitted.
+func (fs *FilterSet) FilterGroupBy(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	s, perr := collectionInputSlice("filter:group_by", in)
+	if perr != nil {
+		return nil, perr
+	}
+	if !param.IsString() {
+		return nil, &pongo2.Error{
+			Sender:    "filter:group_by",
+			OrigError: FilterError{Reason: "filter param must be an attribute name."},
+		}
+	}
+
+	result := make(map[string]interface{})
+	for _, elem := range s {
+		value, ok := attribute(elem, param.String())
+		if !ok {
+			continue
+		}
+		key := scalarString(value)
+		group, _ := result[key].([]interface{})
+		result[key] = append(group, elem)
+	}
+	return pongo2.AsValue(result), nil
+}
+
+// FilterSortBy sorts a list of maps by an attribute. Usage: sort_by:"attr" or
+// sort_by:["attr", "desc"]. The sort is stable, numbers are compared numerically and elements
+// without the attribute sort first.
+//
+//nolint:mnd
+func (fs *FilterSet) FilterSortBy(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	s, perr := collectionInputSlice("filter:sort_by", in)
+	if perr != nil {
+		return nil, perr
+	}
+
+	var attr string
+	descending := false
+	switch {
+	case param.IsString():
+		attr = param.String()
+	case param.CanSlice() && param.Len() == 2:
+		attr = param.Index(0).String()
+		switch param.Index(1).String() {
+		case sortAsc:
+		case sortDesc:
+			descending = true
+		default:
+			return nil, &pongo2.Error{
+				Sender:    "filter:sort_by",
+				OrigError: FilterError{Reason: "sort order must be 'asc' or 'desc'."},
+			}
+		}
+	default:
+		return nil, &pongo2.Error{
+			Sender:    "filter:sort_by",
+			OrigError: FilterError{Reason: "filter param must be an attribute name, or a set of attribute name and sort order."},
+		}
+	}
+
+	result := make([]interface{}, len(s))
+	copy(result, s)
+	sort.SliceStable(result, func(i, j int) bool {
+		a, _ := attribute(result[i], attr)
+		b, _ := attribute(result[j], attr)
+		if descending {
+			return compareValues(a, b) > 0
+		}
+		return compareValues(a, b) < 0
+	})
+	return pongo2.AsValue(result), nil
+}
+
+// FilterZip pairs the elements of the input list with those of the param list, returning a
+// list of two element lists. The result is as long as the shorter list.
+func (fs *FilterSet) FilterZip(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	s, perr := collectionInputSlice("filter:zip", in)
+	if perr != nil {
+		return nil, perr
+	}
+	other, ok := asSlice(param.Interface())
+	if !ok {
+		return nil, &pongo2.Error{
+			Sender:    "filter:zip",
+			OrigError: FilterError{Reason: "filter param must be a list."},
+		}
+	}
+
+	result := make([]interface{}, 0, min(len(s), len(other)))
+	for idx := 0; idx < len(s) && idx < len(other); idx++ {
+		result = append(result, []interface{}{s[idx], other[idx]})
+	}
+	return pongo2.AsValue(result), nil
+}
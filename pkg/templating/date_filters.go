@@ -0,0 +1,389 @@
+package templating
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	// Embed the time zone database so time zone params work in minimal containers.
+	_ "time/tzdata"
+
+	"github.com/flosch/pongo2/v6"
+)
+
+// defaultDateLayouts are tried in order when parsing a date without an explicit layout.
+//
+//nolint:gochecknoglobals
+var defaultDateLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC850,
+	time.ANSIC,
+}
+
+// strftimeLayouts maps strftime directives to equivalent Go layout fragments.
+//
+//nolint:gochecknoglobals
+var strftimeLayouts = map[byte]string{
+	'a': "Mon",
+	'A': "Monday",
+	'b': "Jan",
+	'B': "January",
+	'd': "02",
+	'D': "01/02/06",
+	'e': "_2",
+	'F': "2006-01-02",
+	'h': "Jan",
+	'H': "15",
+	'I': "03",
+	'j': "002",
+	'm': "01",
+	'M': "04",
+	'p': "PM",
+	'R': "15:04",
+	'S': "05",
+	'T': "15:04:05",
+	'y': "06",
+	'Y': "2006",
+	'z': "-0700",
+	'Z': "MST",
+}
+
+// CurrentTime returns the time used by the date filters and the now() function. It is pinned
+// when Now is set, such as by SOURCE_DATE_EPOCH, so renders are reproducible.
+func (fs *FilterSet) CurrentTime() time.Time {
+	if fs.Now != nil {
+		return fs.Now()
+	}
+	return time.Now()
+}
+
+// isStrftime returns true if the format uses strftime directives rather than a Go layout.
+func isStrftime(format string) bool {
+	return strings.Contains(format, "%")
+}
+
+// strftime formats t according to a strftime format string.
+func strftime(t time.Time, format string) (string, error) {
+	out := new(strings.Builder)
+	for idx := 0; idx < len(format); idx++ {
+		if format[idx] != '%' {
+			out.WriteByte(format[idx])
+			continue
+		}
+		idx++
+		if idx == len(format) {
+			return "", fmt.Errorf("format %q ends with an incomplete directive", format)
+		}
+		directive := format[idx]
+		if layout, ok := strftimeLayouts[directive]; ok {
+			out.WriteString(t.Format(layout))
+			continue
+		}
+		switch directive {
+		case '%':
+			out.WriteByte('%')
+		case 'n':
+			out.WriteByte('\n')
+		case 't':
+			out.WriteByte('\t')
+		case 's':
+			out.WriteString(strconv.FormatInt(t.Unix(), 10))
+		case 'u':
+			weekday := int(t.Weekday())
+			if weekday == 0 {
+				weekday = 7
+			}
+			out.WriteString(strconv.Itoa(weekday))
+		case 'w':
+			out.WriteString(strconv.Itoa(int(t.Weekday())))
+		default:
+			return "", fmt.Errorf("unsupported strftime directive %%%c", directive)
+		}
+	}
+	return out.String(), nil
+}
+
+// strftimeToLayout converts a strftime format into a Go layout for parsing.
+func strftimeToLayout(format string) (string, error) {
+	out := new(strings.Builder)
+	for idx := 0; idx < len(format); idx++ {
+		if format[idx] != '%' {
+			out.WriteByte(format[idx])
+			continue
+		}
+		idx++
+		if idx == len(format) {
+			return "", fmt.Errorf("format %q ends with an incomplete directive", format)
+		}
+		if layout, ok := strftimeLayouts[format[idx]]; ok {
+			out.WriteString(layout)
+			continue
+		}
+		switch format[idx] {
+		case '%':
+			out.WriteByte('%')
+		case 'n':
+			out.WriteByte('\n')
+		case 't':
+			out.WriteByte('\t')
+		default:
+			return "", fmt.Errorf("strftime directive %%%c is not supported for parsing", format[idx])
+		}
+	}
+	return out.String(), nil
+}
+
+// parseDate parses a date string with the given strftime or Go layout, or with the default
+// layouts if layout is empty.
+func parseDate(value string, layout string, loc *time.Location) (time.Time, error) {
+	if layout == "" {
+		for _, candidate := range defaultDateLayouts {
+			if t, err := time.ParseInLocation(candidate, value, loc); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("could not parse %q as a date", value)
+	}
+
+	if isStrftime(layout) {
+		converted, err := strftimeToLayout(layout)
+		if err != nil {
+			return time.Time{}, err
+		}
+		layout = converted
+	}
+	return time.ParseInLocation(layout, value, loc)
+}
+
+// asTime converts a filter input to a time. Numbers are seconds since the Unix epoch, and
+// strings are parsed with the default layouts.
+func asTime(sender string, in *pongo2.Value) (time.Time, *pongo2.Error) {
+	switch {
+	case in.IsTime():
+		return in.Time(), nil
+	case in.IsInteger():
+		return time.Unix(int64(in.Integer()), 0).UTC(), nil
+	case in.IsFloat():
+		// JSON input decodes numbers as floats.
+		return time.UnixMilli(int64(in.Float() * 1000)).UTC(), nil //nolint:mnd
+	case in.IsString():
+		t, err := parseDate(in.String(), "", time.UTC)
+		if err != nil {
+			return time.Time{}, &pongo2.Error{Sender: sender, OrigError: err}
+		}
+		return t, nil
+	}
+	if t, ok := in.Interface().(*time.Time); ok && t != nil {
+		return *t, nil
+	}
+	return time.Time{}, &pongo2.Error{
+		Sender:    sender,
+		OrigError: FilterError{Reason: "filter input must be a time, a Unix timestamp or a date string."},
+	}
+}
+
+// durationPattern matches one component of an extended duration string which also allows
+// days (d) and weeks (w).
+var durationPattern = regexp.MustCompile(`([0-9]*(?:\.[0-9]*)?)(w|d)`) //nolint:gochecknoglobals
+
+// parseDuration parses a Go duration string, additionally allowing days and weeks.
+func parseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimLeft(value, "+-")
+
+	var extra time.Duration
+	const day = 24 * time.Hour
+	var convErr error
+	value = durationPattern.ReplaceAllStringFunc(value, func(match string) string {
+		parts := durationPattern.FindStringSubmatch(match)
+		count, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			convErr = fmt.Errorf("invalid duration component %q", match)
+			return ""
+		}
+		unit := day
+		if parts[2] == "w" {
+			unit = 7 * day //nolint:mnd
+		}
+		extra += time.Duration(count * float64(unit))
+		return ""
+	})
+	if convErr != nil {
+		return 0, convErr
+	}
+
+	var result time.Duration
+	if value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration: %w", err)
+		}
+		result = parsed
+	}
+	result += extra
+	if negative {
+		result = -result
+	}
+	return result, nil
+}
+
+// locationParam loads the time zone named by a filter param element.
+func locationParam(sender string, name string) (*time.Location, *pongo2.Error) {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, &pongo2.Error{Sender: sender, OrigError: err}
+	}
+	return loc, nil
+}
+
+// FilterDateFormat formats a time, Unix timestamp or date string. Usage: date_format:"format" or
+// date_format:["format", "timezone"]. The format may use strftime directives (such as
+// "%Y-%m-%d") or a Go layout (such as "2006-01-02"), and defaults to RFC 3339.
+//
+//nolint:mnd
+func (fs *FilterSet) FilterDateFormat(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	t, perr := asTime("filter:date_format", in)
+	if perr != nil {
+		return nil, perr
+	}
+
+	format := time.RFC3339
+	switch {
+	case param.IsNil():
+	case param.IsString():
+		format = param.String()
+	case param.CanSlice() && (param.Len() == 1 || param.Len() == 2):
+		format = param.Index(0).String()
+		if param.Len() == 2 {
+			loc, perr := locationParam("filter:date_format", param.Index(1).String())
+			if perr != nil {
+				return nil, perr
+			}
+			t = t.In(loc)
+		}
+	default:
+		return nil, &pongo2.Error{
+			Sender:    "filter:date_format",
+			OrigError: FilterError{Reason: "filter param must be a format string, or a set of format and time zone."},
+		}
+	}
+
+	if !isStrftime(format) {
+		return pongo2.AsValue(t.Format(format)), nil
+	}
+	result, err := strftime(t, format)
+	if err != nil {
+		return nil, &pongo2.Error{Sender: "filter:date_format", OrigError: err}
+	}
+	return pongo2.AsValue(result), nil
+}
+
+// FilterDateParse parses a date string into a time. Usage: date_parse, date_parse:"layout" or
+// date_parse:["layout", "timezone"]. Without a layout common formats such as RFC 3339 are
+// tried. Dates without a zone are interpreted in the given time zone, or UTC.
+//
+//nolint:mnd
+func (fs *FilterSet) FilterDateParse(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	if !in.IsString() {
+		return nil, &pongo2.Error{
+			Sender:    "filter:date_parse",
+			OrigError: FilterError{Reason: "filter input must be of type 'string'."},
+		}
+	}
+
+	layout := ""
+	loc := time.UTC
+	switch {
+	case param.IsNil():
+	case param.IsString():
+		layout = param.String()
+	case param.CanSlice() && (param.Len() == 1 || param.Len() == 2):
+		layout = param.Index(0).String()
+		if param.Len() == 2 {
+			var perr *pongo2.Error
+			loc, perr = locationParam("filter:date_parse", param.Index(1).String())
+			if perr != nil {
+				return nil, perr
+			}
+		}
+	default:
+		return nil, &pongo2.Error{
+			Sender:    "filter:date_parse",
+			OrigError: FilterError{Reason: "filter param must be a layout string, or a set of layout and time zone."},
+		}
+	}
+
+	t, err := parseDate(in.String(), layout, loc)
+	if err != nil {
+		return nil, &pongo2.Error{Sender: "filter:date_parse", OrigError: err}
+	}
+	return pongo2.AsValue(t), nil
+}
+
+// FilterDuration performs duration arithmetic. Applied to a time with a duration param it
+// returns the time shifted by the duration, e.g. {{ now()|duration:"30d" }}. Applied to a
+// duration string without a param it returns the duration in whole seconds. Durations use Go
+// syntax (such as "1h30m") and may also use days (d) and weeks (w).
+func (fs *FilterSet) FilterDuration(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	if param.IsNil() {
+		if !in.IsString() {
+			return nil, &pongo2.Error{
+				Sender:    "filter:duration",
+				OrigError: FilterError{Reason: "filter input must be a duration string when no param is given."},
+			}
+		}
+		d, err := parseDuration(in.String())
+		if err != nil {
+			return nil, &pongo2.Error{Sender: "filter:duration", OrigError: err}
+		}
+		return pongo2.AsValue(int(d / time.Second)), nil
+	}
+
+	t, perr := asTime("filter:duration", in)
+	if perr != nil {
+		return nil, perr
+	}
+	d, err := parseDuration(param.String())
+	if err != nil {
+		return nil, &pongo2.Error{Sender: "filter:duration", OrigError: err}
+	}
+	return pongo2.AsValue(t.Add(d)), nil
+}
+
+// FilterUnixTimestamp converts a time or date string to seconds since the Unix epoch. Supply
+// "ms", "us" or "ns" as the param for finer units.
+func (fs *FilterSet) FilterUnixTimestamp(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	t, perr := asTime("filter:unix_timestamp", in)
+	if perr != nil {
+		return nil, perr
+	}
+
+	unit := "s"
+	if !param.IsNil() {
+		unit = param.String()
+	}
+	switch unit {
+	case "s":
+		return pongo2.AsValue(t.Unix()), nil
+	case "ms":
+		return pongo2.AsValue(t.UnixMilli()), nil
+	case "us":
+		return pongo2.AsValue(t.UnixMicro()), nil
+	case "ns":
+		return pongo2.AsValue(t.UnixNano()), nil
+	default:
+		return nil, &pongo2.Error{
+			Sender:    "filter:unix_timestamp",
+			OrigError: FilterError{Reason: "unit must be 's', 'ms', 'us' or 'ns'."},
+		}
+	}
+}
@@ -29,7 +29,7 @@ func (te *TemplateEngine) ExecuteTemplate(filterSet *FilterSet, tmpl *LoadedTemp
 	// changes to Pongo2 (ideally per templateset filters) in order to avoid this.
 	filterSet.OutputFileName = outputPath
 	if err := tmpl.Template.ExecuteWriter(ctx, outputWriter); err != nil {
-		return errors.Wrap(err, "ExecuteTemplate template error")
+		return newTemplateError(tmpl.Path, tmpl.Source, err)
 	}
 
 	if finalizer != nil {
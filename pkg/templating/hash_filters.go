@@ -0,0 +1,129 @@
+package templating
+
+import (
+	"crypto/hmac"
+	"crypto/md5"  //nolint:gosec
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+
+	"github.com/flosch/pongo2/v6"
+)
+
+const (
+	encodingHex    = "hex"
+	encodingBase64 = "base64"
+)
+
+// encodeDigest encodes a digest in the named encoding, which defaults to hex.
+func encodeDigest(sender string, digest []byte, encoding string) (*pongo2.Value, *pongo2.Error) {
+	switch encoding {
+	case encodingHex, "":
+		return pongo2.AsValue(hex.EncodeToString(digest)), nil
+	case encodingBase64:
+		return pongo2.AsValue(base64.StdEncoding.EncodeToString(digest)), nil
+	default:
+		return nil, &pongo2.Error{
+			Sender:    sender,
+			OrigError: FilterError{Reason: "output encoding must be 'hex' or 'base64'."},
+		}
+	}
+}
+
+// digestEncodingParam returns the encoding requested by a hashing filter param.
+func digestEncodingParam(sender string, param *pongo2.Value) (string, *pongo2.Error) {
+	switch {
+	case param.IsNil():
+		return encodingHex, nil
+	case param.IsString():
+		return param.String(), nil
+	default:
+		return "", &pongo2.Error{
+			Sender:    sender,
+			OrigError: FilterError{Reason: "filter param must be of type 'string'."},
+		}
+	}
+}
+
+// hashFilter builds a filter which hashes string or []byte input with the given hash.
+func hashFilter(sender string, newHash func() hash.Hash) pongo2.FilterFunction {
+	return func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+		b, perr := parseFilterInput(sender, in)
+		if perr != nil {
+			return nil, perr
+		}
+		encoding, perr := digestEncodingParam(sender, param)
+		if perr != nil {
+			return nil, perr
+		}
+
+		h := newHash()
+		_, _ = h.Write(b)
+		return encodeDigest(sender, h.Sum(nil), encoding)
+	}
+}
+
+// FilterMD5 outputs the MD5 digest of its input. Supply "base64" as the parameter for base64
+// output instead of hex.
+func (fs *FilterSet) FilterMD5(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	return hashFilter("filter:md5", md5.New)(in, param)
+}
+
+// FilterSHA1 outputs the SHA-1 digest of its input.
+func (fs *FilterSet) FilterSHA1(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	return hashFilter("filter:sha1", sha1.New)(in, param)
+}
+
+// FilterSHA256 outputs the SHA-256 digest of its input.
+func (fs *FilterSet) FilterSHA256(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	return hashFilter("filter:sha256", sha256.New)(in, param)
+}
+
+// FilterSHA512 outputs the SHA-512 digest of its input.
+func (fs *FilterSet) FilterSHA512(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	return hashFilter("filter:sha512", sha512.New)(in, param)
+}
+
+// FilterCRC32 outputs the IEEE CRC-32 checksum of its input as big-endian bytes.
+func (fs *FilterSet) FilterCRC32(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	return hashFilter("filter:crc32", func() hash.Hash { return crc32.NewIEEE() })(in, param)
+}
+
+// FilterHMACSHA256 outputs the HMAC-SHA256 of its input. Usage: hmac_sha256:"key" or
+// hmac_sha256:["key", "base64"].
+//
+//nolint:mnd
+func (fs *FilterSet) FilterHMACSHA256(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	b, perr := parseFilterInput("filter:hmac_sha256", in)
+	if perr != nil {
+		return nil, perr
+	}
+
+	var key []byte
+	encoding := encodingHex
+	keyBytes, isBytes := param.Interface().([]byte)
+	switch {
+	case param.IsString():
+		key = []byte(param.String())
+	case isBytes:
+		key = keyBytes
+	case param.CanSlice() && (param.Len() == 1 || param.Len() == 2):
+		key = []byte(param.Index(0).String())
+		if param.Len() == 2 {
+			encoding = param.Index(1).String()
+		}
+	default:
+		return nil, &pongo2.Error{
+			Sender:    "filter:hmac_sha256",
+			OrigError: FilterError{Reason: "filter param must be a key string, or a set of key and output encoding."},
+		}
+	}
+
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(b)
+	return encodeDigest("filter:hmac_sha256", mac.Sum(nil), encoding)
+}
@@ -0,0 +1,221 @@
+package templating
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"strings"
+
+	"github.com/flosch/pongo2/v6"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	wireguardKeySize  = 32
+	opensshBlockSize  = 8
+	opensshKeyMagic   = "openssh-key-v1\x00"
+	opensshCipherNone = "none"
+)
+
+// sshString appends an SSH wire format string (a uint32 length followed by the bytes).
+func sshString(buf *bytes.Buffer, value []byte) {
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(value))) //nolint:gosec
+	buf.Write(value)
+}
+
+// marshalOpenSSHEd25519 encodes an unencrypted ed25519 key in the OpenSSH private key format.
+// ssh.MarshalPrivateKey always uses random check bytes, so they are read from source instead
+// to keep seeded keys reproducible.
+func marshalOpenSSHEd25519(key ed25519.PrivateKey, comment string, source io.Reader) ([]byte, error) {
+	var check uint32
+	if err := binary.Read(source, binary.BigEndian, &check); err != nil {
+		return nil, err
+	}
+	pub := key.Public().(ed25519.PublicKey) //nolint:forcetypeassert
+
+	private := new(bytes.Buffer)
+	_ = binary.Write(private, binary.BigEndian, check)
+	_ = binary.Write(private, binary.BigEndian, check)
+	sshString(private, []byte(ssh.KeyAlgoED25519))
+	sshString(private, pub)
+	sshString(private, key)
+	sshString(private, []byte(comment))
+	for pad := byte(1); private.Len()%opensshBlockSize != 0; pad++ {
+		private.WriteByte(pad)
+	}
+
+	publicBlob := new(bytes.Buffer)
+	sshString(publicBlob, []byte(ssh.KeyAlgoED25519))
+	sshString(publicBlob, pub)
+
+	out := new(bytes.Buffer)
+	out.WriteString(opensshKeyMagic)
+	sshString(out, []byte(opensshCipherNone))
+	sshString(out, []byte(opensshCipherNone))
+	sshString(out, nil)
+	_ = binary.Write(out, binary.BigEndian, uint32(1))
+	sshString(out, publicBlob.Bytes())
+	sshString(out, private.Bytes())
+
+	return pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: out.Bytes()}), nil
+}
+
+// authorizedKey formats a public key as an authorized_keys line with an optional comment.
+func authorizedKey(key ssh.PublicKey, comment string) string {
+	line := strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(key)), "\n")
+	if comment != "" {
+		line += " " + comment
+	}
+	return line
+}
+
+// sshPublicKeyInput parses an SSH public key in authorized_keys format, or derives it from an
+// unencrypted private key.
+func sshPublicKeyInput(sender string, in *pongo2.Value) (ssh.PublicKey, *pongo2.Error) {
+	data, perr := parseFilterInput(sender, in)
+	if perr != nil {
+		return nil, perr
+	}
+	if bytes.Contains(data, []byte("PRIVATE KEY")) {
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, &pongo2.Error{Sender: sender, OrigError: err}
+		}
+		return signer.PublicKey(), nil
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, &pongo2.Error{Sender: sender, OrigError: err}
+	}
+	return key, nil
+}
+
+func optionalString(param *pongo2.Value) string {
+	if param.IsNil() {
+		return ""
+	}
+	return param.String()
+}
+
+// FilterSSHPublicKey derives the authorized_keys line of an unencrypted SSH private key, in
+// either OpenSSH or PEM format. An optional param is appended as the comment.
+func (fs *FilterSet) FilterSSHPublicKey(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	data, perr := parseFilterInput("filter:ssh_public_key", in)
+	if perr != nil {
+		return nil, perr
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, &pongo2.Error{Sender: "filter:ssh_public_key", OrigError: err}
+	}
+	return pongo2.AsValue(authorizedKey(signer.PublicKey(), optionalString(param))), nil
+}
+
+// FilterSSHFingerprint returns the SHA256 fingerprint of an SSH public or private key as shown
+// by ssh-keygen -l. Supply "md5" as the param for the legacy MD5 format.
+func (fs *FilterSet) FilterSSHFingerprint(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	key, perr := sshPublicKeyInput("filter:ssh_fingerprint", in)
+	if perr != nil {
+		return nil, perr
+	}
+	switch optionalString(param) {
+	case "", "sha256":
+		return pongo2.AsValue(ssh.FingerprintSHA256(key)), nil
+	case "md5":
+		return pongo2.AsValue("MD5:" + ssh.FingerprintLegacyMD5(key)), nil
+	default:
+		return nil, &pongo2.Error{
+			Sender:    "filter:ssh_fingerprint",
+			OrigError: FilterError{Reason: "filter param must be 'sha256' or 'md5'."},
+		}
+	}
+}
+
+// FilterSSHKeygen generates an ed25519 SSH keypair from the seed input, returning a map of
+// "private" (OpenSSH format) and "public" (authorized_keys format). An optional param is used
+// as the key comment.
+func (fs *FilterSet) FilterSSHKeygen(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	source, _, perr := fs.randomSource("filter:ssh_keygen", in)
+	if perr != nil {
+		return nil, perr
+	}
+	comment := optionalString(param)
+
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := io.ReadFull(source, seed); err != nil {
+		return nil, &pongo2.Error{Sender: "filter:ssh_keygen", OrigError: err}
+	}
+	key := ed25519.NewKeyFromSeed(seed)
+
+	private, err := marshalOpenSSHEd25519(key, comment, source)
+	if err != nil {
+		return nil, &pongo2.Error{Sender: "filter:ssh_keygen", OrigError: err}
+	}
+	public, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		return nil, &pongo2.Error{Sender: "filter:ssh_keygen", OrigError: err}
+	}
+
+	return pongo2.AsValue(map[string]interface{}{
+		"private": string(private),
+		"public":  authorizedKey(public, comment),
+	}), nil
+}
+
+// wireguardPublicKey derives the base64 public key of a Curve25519 private key.
+func wireguardPublicKey(private []byte) (string, error) {
+	key, err := ecdh.X25519().NewPrivateKey(private)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// FilterWireguardPublicKey derives a WireGuard public key from a base64 private key, as
+// wg pubkey does.
+func (fs *FilterSet) FilterWireguardPublicKey(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	private, err := base64.StdEncoding.DecodeString(strings.TrimSpace(in.String()))
+	if err != nil || len(private) != wireguardKeySize {
+		return nil, &pongo2.Error{
+			Sender:    "filter:wireguard_public_key",
+			OrigError: FilterError{Reason: "filter input must be a base64 encoded 32 byte private key."},
+		}
+	}
+	public, err := wireguardPublicKey(private)
+	if err != nil {
+		return nil, &pongo2.Error{Sender: "filter:wireguard_public_key", OrigError: err}
+	}
+	return pongo2.AsValue(public), nil
+}
+
+// FilterWireguardKeygen generates a WireGuard keypair from the seed input, returning a map of
+// base64 "private" and "public" keys.
+//
+//nolint:mnd
+func (fs *FilterSet) FilterWireguardKeygen(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	source, _, perr := fs.randomSource("filter:wireguard_keygen", in)
+	if perr != nil {
+		return nil, perr
+	}
+
+	private := make([]byte, wireguardKeySize)
+	if _, err := io.ReadFull(source, private); err != nil {
+		return nil, &pongo2.Error{Sender: "filter:wireguard_keygen", OrigError: err}
+	}
+	// Clamp the key as wg genkey does.
+	private[0] &= 248
+	private[31] = (private[31] & 127) | 64
+
+	public, err := wireguardPublicKey(private)
+	if err != nil {
+		return nil, &pongo2.Error{Sender: "filter:wireguard_keygen", OrigError: err}
+	}
+	return pongo2.AsValue(map[string]interface{}{
+		"private": base64.StdEncoding.EncodeToString(private),
+		"public":  public,
+	}), nil
+}
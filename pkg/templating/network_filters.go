@@ -0,0 +1,251 @@
+package templating
+
+import (
+	"fmt"
+	"math/big"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/flosch/pongo2/v6"
+)
+
+const ipv4Bits = 32
+
+func prefixInput(sender string, in *pongo2.Value) (netip.Prefix, *pongo2.Error) {
+	prefix, err := netip.ParsePrefix(strings.TrimSpace(in.String()))
+	if err != nil {
+		return netip.Prefix{}, &pongo2.Error{Sender: sender, OrigError: err}
+	}
+	return prefix.Masked(), nil
+}
+
+// addrInput parses an IP address filter input. A CIDR prefix is accepted, in which case its
+// address is used.
+func addrInput(sender string, in *pongo2.Value) (netip.Addr, *pongo2.Error) {
+	value := strings.TrimSpace(in.String())
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Addr{}, &pongo2.Error{Sender: sender, OrigError: err}
+		}
+		return prefix.Addr(), nil
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}, &pongo2.Error{Sender: sender, OrigError: err}
+	}
+	return addr.Unmap(), nil
+}
+
+func integerParam(sender string, value *pongo2.Value, name string) (int64, *pongo2.Error) {
+	if value.IsInteger() {
+		return int64(value.Integer()), nil
+	}
+	// JSON input decodes numbers as floats.
+	if value.IsFloat() && value.Float() == float64(int64(value.Float())) {
+		return int64(value.Float()), nil
+	}
+	// Set notation returns strings
+	result, err := strconv.ParseInt(value.String(), 10, 64)
+	if err != nil {
+		return 0, &pongo2.Error{
+			Sender:    sender,
+			OrigError: FilterError{Reason: fmt.Sprintf("%s must be an integer", name)},
+		}
+	}
+	return result, nil
+}
+
+func addrToInt(addr netip.Addr) *big.Int {
+	return new(big.Int).SetBytes(addr.AsSlice())
+}
+
+func intToAddr(value *big.Int, is4 bool) netip.Addr {
+	size := 16
+	if is4 {
+		size = 4
+	}
+	b := value.FillBytes(make([]byte, size))
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// offsetAddr returns the address num positions into prefix, counting back from the end of
+// the prefix if num is negative.
+func offsetAddr(prefix netip.Prefix, num int64) (netip.Addr, error) {
+	hostBits := uint(prefix.Addr().BitLen() - prefix.Bits()) //nolint:gosec
+	size := new(big.Int).Lsh(big.NewInt(1), hostBits)
+
+	offset := big.NewInt(num)
+	if num < 0 {
+		offset.Add(offset, size)
+	}
+	if offset.Sign() < 0 || offset.Cmp(size) >= 0 {
+		return netip.Addr{}, fmt.Errorf("prefix %s has no host number %d", prefix, num)
+	}
+	return intToAddr(offset.Add(offset, addrToInt(prefix.Addr())), prefix.Addr().Is4()), nil
+}
+
+// FilterCIDRHost returns the address of the given host number within a CIDR prefix. Negative
+// numbers count back from the end of the prefix. Usage: {{ "10.0.0.0/24"|cidr_host:5 }}.
+func (fs *FilterSet) FilterCIDRHost(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	prefix, perr := prefixInput("filter:cidr_host", in)
+	if perr != nil {
+		return nil, perr
+	}
+	num, perr := integerParam("filter:cidr_host", param, "host number")
+	if perr != nil {
+		return nil, perr
+	}
+
+	addr, err := offsetAddr(prefix, num)
+	if err != nil {
+		return nil, &pongo2.Error{Sender: "filter:cidr_host", OrigError: err}
+	}
+	return pongo2.AsValue(addr.String()), nil
+}
+
+// FilterCIDRSubnet calculates a subnet of a CIDR prefix, extending it by newbits and selecting
+// subnet netnum. Usage: {{ "10.0.0.0/16"|cidr_subnet:[8, 2] }} gives 10.0.2.0/24.
+//
+//nolint:mnd
+func (fs *FilterSet) FilterCIDRSubnet(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	prefix, perr := prefixInput("filter:cidr_subnet", in)
+	if perr != nil {
+		return nil, perr
+	}
+	if !param.CanSlice() || param.IsString() || param.Len() != 2 {
+		return nil, &pongo2.Error{
+			Sender:    "filter:cidr_subnet",
+			OrigError: FilterError{Reason: "filter param must be a set of newbits and netnum."},
+		}
+	}
+	newBits, perr := integerParam("filter:cidr_subnet", param.Index(0), "newbits")
+	if perr != nil {
+		return nil, perr
+	}
+	netNum, perr := integerParam("filter:cidr_subnet", param.Index(1), "netnum")
+	if perr != nil {
+		return nil, perr
+	}
+
+	bits := int64(prefix.Bits()) + newBits
+	if newBits < 0 || bits > int64(prefix.Addr().BitLen()) {
+		return nil, &pongo2.Error{
+			Sender:    "filter:cidr_subnet",
+			OrigError: FilterError{Reason: fmt.Sprintf("cannot extend prefix %s by %d bits", prefix, newBits)},
+		}
+	}
+	if netNum < 0 || (newBits < 63 && netNum >= int64(1)<<newBits) {
+		return nil, &pongo2.Error{
+			Sender:    "filter:cidr_subnet",
+			OrigError: FilterError{Reason: fmt.Sprintf("prefix %s has no %d bit subnet number %d", prefix, newBits, netNum)},
+		}
+	}
+
+	hostBits := uint(int64(prefix.Addr().BitLen()) - bits) //nolint:gosec
+	value := new(big.Int).Lsh(big.NewInt(netNum), hostBits)
+	value.Or(value, addrToInt(prefix.Addr()))
+	subnet := netip.PrefixFrom(intToAddr(value, prefix.Addr().Is4()), int(bits))
+	return pongo2.AsValue(subnet.String()), nil
+}
+
+// FilterCIDRNetmask returns the dotted decimal netmask of an IPv4 CIDR prefix.
+func (fs *FilterSet) FilterCIDRNetmask(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	prefix, perr := prefixInput("filter:cidr_netmask", in)
+	if perr != nil {
+		return nil, perr
+	}
+	if !prefix.Addr().Is4() {
+		return nil, &pongo2.Error{
+			Sender:    "filter:cidr_netmask",
+			OrigError: FilterError{Reason: "netmasks are only supported for IPv4 prefixes."},
+		}
+	}
+
+	mask := new(big.Int).Lsh(big.NewInt(1), ipv4Bits)
+	mask.Sub(mask, new(big.Int).Lsh(big.NewInt(1), uint(ipv4Bits-prefix.Bits()))) //nolint:gosec
+	return pongo2.AsValue(intToAddr(mask, true).String()), nil
+}
+
+// FilterIPInCIDR returns true if the input address is contained in the CIDR prefix param.
+func (fs *FilterSet) FilterIPInCIDR(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	addr, perr := addrInput("filter:ip_in_cidr", in)
+	if perr != nil {
+		return nil, perr
+	}
+	prefix, perr := prefixInput("filter:ip_in_cidr", param)
+	if perr != nil {
+		return nil, perr
+	}
+	return pongo2.AsValue(prefix.Contains(addr)), nil
+}
+
+// FilterIPVersion returns 4 or 6 for an IP address or CIDR prefix.
+func (fs *FilterSet) FilterIPVersion(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	addr, perr := addrInput("filter:ip_version", in)
+	if perr != nil {
+		return nil, perr
+	}
+	if addr.Is4() {
+		return pongo2.AsValue(4), nil //nolint:mnd
+	}
+	return pongo2.AsValue(6), nil //nolint:mnd
+}
+
+// FilterReverseDNSName returns the in-addr.arpa or ip6.arpa name of an IP address. Applied to
+// a CIDR prefix it returns the reverse zone name, which requires the prefix length to be a
+// multiple of 8 for IPv4 or 4 for IPv6.
+//
+//nolint:mnd
+func (fs *FilterSet) FilterReverseDNSName(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	addr, perr := addrInput("filter:reverse_dns_name", in)
+	if perr != nil {
+		return nil, perr
+	}
+
+	bits := addr.BitLen()
+	if strings.Contains(in.String(), "/") {
+		prefix, perr := prefixInput("filter:reverse_dns_name", in)
+		if perr != nil {
+			return nil, perr
+		}
+		addr = prefix.Addr()
+		bits = prefix.Bits()
+	}
+
+	var labels []string
+	var suffix string
+	if addr.Is4() {
+		if bits%8 != 0 {
+			return nil, &pongo2.Error{
+				Sender:    "filter:reverse_dns_name",
+				OrigError: FilterError{Reason: "IPv4 prefix length must be a multiple of 8."},
+			}
+		}
+		for _, octet := range addr.AsSlice()[:bits/8] {
+			labels = append(labels, strconv.Itoa(int(octet)))
+		}
+		suffix = "in-addr.arpa"
+	} else {
+		if bits%4 != 0 {
+			return nil, &pongo2.Error{
+				Sender:    "filter:reverse_dns_name",
+				OrigError: FilterError{Reason: "IPv6 prefix length must be a multiple of 4."},
+			}
+		}
+		for _, octet := range addr.AsSlice() {
+			labels = append(labels, strconv.FormatInt(int64(octet>>4), 16), strconv.FormatInt(int64(octet&0xf), 16))
+		}
+		labels = labels[:bits/4]
+		suffix = "ip6.arpa"
+	}
+
+	reversed := make([]string, 0, len(labels)+1)
+	for idx := len(labels) - 1; idx >= 0; idx-- {
+		reversed = append(reversed, labels[idx])
+	}
+	reversed = append(reversed, suffix)
+	return pongo2.AsValue(strings.Join(reversed, ".")), nil
+}
@@ -0,0 +1,150 @@
+package templating
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/pelletier/go-toml"
+	"gopkg.in/ini.v1"
+	"gopkg.in/yaml.v3"
+)
+
+// valueAsBytes returns the content of a string or []byte value.
+func valueAsBytes(in *pongo2.Value) ([]byte, bool) {
+	if in.IsString() {
+		return []byte(in.String()), true
+	}
+
+	b, ok := in.Interface().([]byte)
+	return b, ok
+}
+
+// parseFilterInput extracts the input of a parsing filter, which may be a string or []byte.
+func parseFilterInput(sender string, in *pongo2.Value) ([]byte, *pongo2.Error) {
+	b, ok := valueAsBytes(in)
+	if !ok {
+		return nil, &pongo2.Error{
+			Sender:    sender,
+			OrigError: FilterError{Reason: "filter requires a []byte or string input"},
+		}
+	}
+	return b, nil
+}
+
+func (fs *FilterSet) FilterFromJSON(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	b, perr := parseFilterInput("filter:from_json", in)
+	if perr != nil {
+		return nil, perr
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+
+	var result interface{}
+	if err := decoder.Decode(&result); err != nil {
+		return nil, &pongo2.Error{
+			Sender:    "filter:from_json",
+			OrigError: err,
+		}
+	}
+	// Only whitespace may follow the value.
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		return nil, &pongo2.Error{
+			Sender:    "filter:from_json",
+			OrigError: FilterError{Reason: "unexpected data after the JSON value."},
+		}
+	}
+	return pongo2.AsValue(normalizeJSONNumbers(result)), nil
+}
+
+// normalizeJSONNumbers converts json.Number values to int64 where they are integral and
+// float64 otherwise, so integers render without a fractional part.
+func normalizeJSONNumbers(v interface{}) interface{} {
+	switch value := v.(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+		f, _ := value.Float64()
+		return f
+	case map[string]interface{}:
+		for k, elem := range value {
+			value[k] = normalizeJSONNumbers(elem)
+		}
+		return value
+	case []interface{}:
+		for idx, elem := range value {
+			value[idx] = normalizeJSONNumbers(elem)
+		}
+		return value
+	default:
+		return v
+	}
+}
+
+func (fs *FilterSet) FilterFromYAML(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	b, perr := parseFilterInput("filter:from_yaml", in)
+	if perr != nil {
+		return nil, perr
+	}
+
+	// yaml.v3 decodes mappings to map[string]interface{} which the other filters (such as
+	// to_json) can consume directly.
+	var result interface{}
+	if err := yaml.Unmarshal(b, &result); err != nil {
+		return nil, &pongo2.Error{
+			Sender:    "filter:from_yaml",
+			OrigError: err,
+		}
+	}
+	return pongo2.AsValue(result), nil
+}
+
+func (fs *FilterSet) FilterFromTOML(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	b, perr := parseFilterInput("filter:from_toml", in)
+	if perr != nil {
+		return nil, perr
+	}
+
+	tree, err := toml.LoadBytes(b)
+	if err != nil {
+		return nil, &pongo2.Error{
+			Sender:    "filter:from_toml",
+			OrigError: err,
+		}
+	}
+	return pongo2.AsValue(tree.ToMap()), nil
+}
+
+// FilterFromINI parses an INI document into a map of sections to maps of keys. Keys which
+// appear before the first section are placed in the "DEFAULT" section.
+func (fs *FilterSet) FilterFromINI(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	b, perr := parseFilterInput("filter:from_ini", in)
+	if perr != nil {
+		return nil, perr
+	}
+
+	cfg, err := ini.Load(b)
+	if err != nil {
+		return nil, &pongo2.Error{
+			Sender:    "filter:from_ini",
+			OrigError: err,
+		}
+	}
+
+	result := make(map[string]interface{})
+	for _, section := range cfg.Sections() {
+		if section.Name() == ini.DefaultSection && len(section.Keys()) == 0 {
+			continue
+		}
+		values := make(map[string]interface{})
+		for _, key := range section.Keys() {
+			values[key.Name()] = key.Value()
+		}
+		result[section.Name()] = values
+	}
+	return pongo2.AsValue(result), nil
+}
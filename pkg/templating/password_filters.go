@@ -0,0 +1,392 @@
+package templating
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/flosch/pongo2/v6"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/blowfish"
+)
+
+// The base64 alphabets used by bcrypt and crypt(3) respectively.
+const (
+	bcryptAlphabet = "./ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+	cryptAlphabet  = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+const (
+	bcryptDefaultCost  = 10
+	bcryptMinCost      = 4
+	bcryptMaxCost      = 31
+	bcryptSaltLen      = 16
+	bcryptEncodedSalt  = 22
+	bcryptMaxPassword  = 72
+	sha512CryptRounds  = 5000
+	sha512CryptSaltLen = 16
+	argon2SaltLen      = 16
+	argon2KeyLen       = 32
+	argon2DefaultTime  = 3
+	argon2DefaultMem   = 64 * 1024
+	argon2DefaultPar   = 4
+)
+
+//nolint:gochecknoglobals
+var bcryptEncoding = base64.NewEncoding(bcryptAlphabet).WithPadding(base64.NoPadding)
+
+// passwordParams splits a password hashing filter param into an optional salt and further
+// numeric set elements. A nil param means a random salt and default settings.
+func passwordParams(sender string, param *pongo2.Value, maxNumeric int) (string, []int, *pongo2.Error) {
+	switch {
+	case param.IsNil():
+		return "", nil, nil
+	case param.IsString():
+		return param.String(), nil, nil
+	case param.CanSlice() && param.Len() >= 1 && param.Len() <= maxNumeric+1:
+		numeric := make([]int, 0, param.Len()-1)
+		for idx := 1; idx < param.Len(); idx++ {
+			// Set notation returns strings
+			value, err := strconv.Atoi(param.Index(idx).String())
+			if err != nil {
+				return "", nil, &pongo2.Error{
+					Sender:    sender,
+					OrigError: FilterError{Reason: fmt.Sprintf("element %d of filter param must be an integer", idx)},
+				}
+			}
+			numeric = append(numeric, value)
+		}
+		return param.Index(0).String(), numeric, nil
+	default:
+		return "", nil, &pongo2.Error{
+			Sender:    sender,
+			OrigError: FilterError{Reason: "filter param must be a salt string, or a set starting with a salt string."},
+		}
+	}
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("could not generate random salt: %w", err)
+	}
+	return b, nil
+}
+
+// bcryptSalt determines the raw bcrypt salt. A 22 character bcrypt-encoded salt is used
+// directly, any other string is hashed to derive one, and an empty string generates a
+// random salt.
+func bcryptSalt(salt string) ([]byte, error) {
+	if salt == "" {
+		return randomBytes(bcryptSaltLen)
+	}
+	if len(salt) == bcryptEncodedSalt {
+		if decoded, err := bcryptEncoding.DecodeString(salt); err == nil && len(decoded) == bcryptSaltLen {
+			// Only accept the salt verbatim if it round-trips, since the final character only
+			// carries 2 significant bits.
+			if bcryptEncoding.EncodeToString(decoded) == salt {
+				return decoded, nil
+			}
+		}
+	}
+	sum := sha256.Sum256([]byte(salt))
+	return sum[:bcryptSaltLen], nil
+}
+
+// bcryptHash implements bcrypt with a caller-supplied salt, which golang.org/x/crypto/bcrypt
+// does not allow.
+func bcryptHash(password []byte, cost int, salt []byte, prefix string) (string, error) {
+	if cost < bcryptMinCost || cost > bcryptMaxCost {
+		return "", fmt.Errorf("bcrypt cost must be between %d and %d", bcryptMinCost, bcryptMaxCost)
+	}
+
+	// bcrypt keys are NUL terminated and limited to 72 bytes.
+	key := make([]byte, 0, len(password)+1)
+	key = append(key, password...)
+	key = append(key, 0)
+	if len(key) > bcryptMaxPassword {
+		key = key[:bcryptMaxPassword]
+	}
+
+	cipher, err := blowfish.NewSaltedCipher(key, salt)
+	if err != nil {
+		return "", fmt.Errorf("bcrypt setup failed: %w", err)
+	}
+	for i := 0; i < 1<<cost; i++ {
+		blowfish.ExpandKey(key, cipher)
+		blowfish.ExpandKey(salt, cipher)
+	}
+
+	cipherData := []byte("OrpheanBeholderScryDoubt")
+	const blockSize = 8
+	const encryptRounds = 64
+	for i := 0; i < len(cipherData); i += blockSize {
+		for j := 0; j < encryptRounds; j++ {
+			cipher.Encrypt(cipherData[i:i+blockSize], cipherData[i:i+blockSize])
+		}
+	}
+
+	// The final byte of the cipher data is dropped by the bcrypt format.
+	return fmt.Sprintf("$%s$%02d$%s%s", prefix, cost, bcryptEncoding.EncodeToString(salt),
+		bcryptEncoding.EncodeToString(cipherData[:len(cipherData)-1])), nil
+}
+
+// cryptB64From24Bit implements the b64_from_24bit encoding of crypt(3).
+func cryptB64From24Bit(b2, b1, b0 byte, n int, out *strings.Builder) {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0) //nolint:mnd
+	for ; n > 0; n-- {
+		out.WriteByte(cryptAlphabet[w&0x3f]) //nolint:mnd
+		w >>= 6
+	}
+}
+
+// sha512Crypt implements the SHA-512 based crypt(3) scheme ($6$) used in /etc/shadow.
+//
+//nolint:mnd
+func sha512Crypt(password []byte, salt []byte, rounds int, customRounds bool) string {
+	if len(salt) > sha512CryptSaltLen {
+		salt = salt[:sha512CryptSaltLen]
+	}
+
+	altHash := sha512.New()
+	altHash.Write(password)
+	altHash.Write(salt)
+	altHash.Write(password)
+	alt := altHash.Sum(nil)
+
+	hash := sha512.New()
+	hash.Write(password)
+	hash.Write(salt)
+	for i := len(password); i > 0; i -= 64 {
+		hash.Write(alt[:min(i, 64)])
+	}
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			hash.Write(alt)
+		} else {
+			hash.Write(password)
+		}
+	}
+	digest := hash.Sum(nil)
+
+	pHash := sha512.New()
+	for range password {
+		pHash.Write(password)
+	}
+	pDigest := pHash.Sum(nil)
+	pBytes := make([]byte, 0, len(password))
+	for len(pBytes) < len(password) {
+		pBytes = append(pBytes, pDigest[:min(len(password)-len(pBytes), 64)]...)
+	}
+
+	sHash := sha512.New()
+	for i := 0; i < 16+int(digest[0]); i++ {
+		sHash.Write(salt)
+	}
+	sBytes := sHash.Sum(nil)[:len(salt)]
+
+	for i := 0; i < rounds; i++ {
+		round := sha512.New()
+		if i&1 != 0 {
+			round.Write(pBytes)
+		} else {
+			round.Write(digest)
+		}
+		if i%3 != 0 {
+			round.Write(sBytes)
+		}
+		if i%7 != 0 {
+			round.Write(pBytes)
+		}
+		if i&1 != 0 {
+			round.Write(digest)
+		} else {
+			round.Write(pBytes)
+		}
+		digest = round.Sum(nil)
+	}
+
+	out := new(strings.Builder)
+	out.WriteString("$6$")
+	if customRounds {
+		fmt.Fprintf(out, "rounds=%d$", rounds)
+	}
+	out.Write(salt)
+	out.WriteString("$")
+
+	order := [][3]int{
+		{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4}, {47, 5, 26}, {6, 27, 48},
+		{28, 49, 7}, {50, 8, 29}, {9, 30, 51}, {31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13},
+		{56, 14, 35}, {15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19}, {62, 20, 41},
+	}
+	for _, idx := range order {
+		cryptB64From24Bit(digest[idx[0]], digest[idx[1]], digest[idx[2]], 4, out)
+	}
+	cryptB64From24Bit(0, 0, digest[63], 2, out)
+
+	return out.String()
+}
+
+// FilterBcrypt hashes its input with bcrypt. Usage: bcrypt, bcrypt:"salt" or bcrypt:["salt", cost].
+// Without a salt a random salt is used and the output changes on every render.
+func (fs *FilterSet) FilterBcrypt(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	password, perr := parseFilterInput("filter:bcrypt", in)
+	if perr != nil {
+		return nil, perr
+	}
+	saltParam, numeric, perr := passwordParams("filter:bcrypt", param, 1)
+	if perr != nil {
+		return nil, perr
+	}
+
+	cost := bcryptDefaultCost
+	if len(numeric) > 0 {
+		cost = numeric[0]
+	}
+
+	salt, err := bcryptSalt(saltParam)
+	if err != nil {
+		return nil, &pongo2.Error{Sender: "filter:bcrypt", OrigError: err}
+	}
+
+	result, err := bcryptHash(password, cost, salt, "2a")
+	if err != nil {
+		return nil, &pongo2.Error{Sender: "filter:bcrypt", OrigError: err}
+	}
+	return pongo2.AsValue(result), nil
+}
+
+// FilterSHA512Crypt hashes its input in the crypt(3) SHA-512 format used by /etc/shadow.
+// Usage: sha512_crypt, sha512_crypt:"salt" or sha512_crypt:["salt", rounds].
+func (fs *FilterSet) FilterSHA512Crypt(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	password, perr := parseFilterInput("filter:sha512_crypt", in)
+	if perr != nil {
+		return nil, perr
+	}
+	saltParam, numeric, perr := passwordParams("filter:sha512_crypt", param, 1)
+	if perr != nil {
+		return nil, perr
+	}
+
+	rounds := sha512CryptRounds
+	customRounds := false
+	if len(numeric) > 0 {
+		const minRounds, maxRounds = 1000, 999999999
+		rounds = max(minRounds, min(maxRounds, numeric[0]))
+		customRounds = true
+	}
+
+	salt := []byte(saltParam)
+	if len(salt) == 0 {
+		raw, err := randomBytes(sha512CryptSaltLen)
+		if err != nil {
+			return nil, &pongo2.Error{Sender: "filter:sha512_crypt", OrigError: err}
+		}
+		for idx := range raw {
+			raw[idx] = cryptAlphabet[int(raw[idx])%len(cryptAlphabet)]
+		}
+		salt = raw
+	}
+	if strings.ContainsAny(string(salt), "$:\n") {
+		return nil, &pongo2.Error{
+			Sender:    "filter:sha512_crypt",
+			OrigError: FilterError{Reason: "salt must not contain '$', ':' or newlines."},
+		}
+	}
+
+	return pongo2.AsValue(sha512Crypt(password, salt, rounds, customRounds)), nil
+}
+
+// FilterArgon2id hashes its input with argon2id in PHC string format. Usage: argon2id,
+// argon2id:"salt" or argon2id:["salt", time, memoryKiB, threads].
+//
+//nolint:mnd
+func (fs *FilterSet) FilterArgon2id(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	password, perr := parseFilterInput("filter:argon2id", in)
+	if perr != nil {
+		return nil, perr
+	}
+	saltParam, numeric, perr := passwordParams("filter:argon2id", param, 3)
+	if perr != nil {
+		return nil, perr
+	}
+
+	settings := []int{argon2DefaultTime, argon2DefaultMem, argon2DefaultPar}
+	copy(settings, numeric)
+	for _, setting := range settings {
+		if setting < 1 || setting > 1<<32-1 {
+			return nil, &pongo2.Error{
+				Sender:    "filter:argon2id",
+				OrigError: FilterError{Reason: "argon2id time, memory and threads must be positive integers."},
+			}
+		}
+	}
+	if settings[2] > 255 {
+		return nil, &pongo2.Error{
+			Sender:    "filter:argon2id",
+			OrigError: FilterError{Reason: "argon2id threads must be at most 255."},
+		}
+	}
+
+	salt := []byte(saltParam)
+	if len(salt) == 0 {
+		var err error
+		salt, err = randomBytes(argon2SaltLen)
+		if err != nil {
+			return nil, &pongo2.Error{Sender: "filter:argon2id", OrigError: err}
+		}
+	}
+
+	//nolint:gosec
+	key := argon2.IDKey(password, salt, uint32(settings[0]), uint32(settings[1]), uint8(settings[2]), argon2KeyLen)
+	result := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, settings[1], settings[0], settings[2],
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+	return pongo2.AsValue(result), nil
+}
+
+// FilterHtpasswd outputs an Apache htpasswd line for the given user, using bcrypt. Usage:
+// htpasswd:"user" or htpasswd:["user", "salt"].
+//
+//nolint:mnd
+func (fs *FilterSet) FilterHtpasswd(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	password, perr := parseFilterInput("filter:htpasswd", in)
+	if perr != nil {
+		return nil, perr
+	}
+
+	var user, saltParam string
+	switch {
+	case param.IsString():
+		user = param.String()
+	case param.CanSlice() && param.Len() == 2:
+		user = param.Index(0).String()
+		saltParam = param.Index(1).String()
+	default:
+		return nil, &pongo2.Error{
+			Sender:    "filter:htpasswd",
+			OrigError: FilterError{Reason: "filter param must be a user name, or a set of user name and salt."},
+		}
+	}
+	if user == "" || strings.ContainsAny(user, ":\n") {
+		return nil, &pongo2.Error{
+			Sender:    "filter:htpasswd",
+			OrigError: FilterError{Reason: "user name must be non-empty and not contain ':' or newlines."},
+		}
+	}
+
+	salt, err := bcryptSalt(saltParam)
+	if err != nil {
+		return nil, &pongo2.Error{Sender: "filter:htpasswd", OrigError: err}
+	}
+
+	// Apache identifies bcrypt hashes with the $2y$ prefix.
+	result, err := bcryptHash(password, bcryptDefaultCost, salt, "2y")
+	if err != nil {
+		return nil, &pongo2.Error{Sender: "filter:htpasswd", OrigError: err}
+	}
+	return pongo2.AsValue(user + ":" + result), nil
+}
@@ -0,0 +1,138 @@
+package templating
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/flosch/pongo2/v6"
+)
+
+// outputDir returns the directory of the output file currently being rendered. This is
+// p2.OutputDir in directory mode, but in single file mode it is the directory of the output
+// file while p2.OutputDir is the working directory. Output to stdout uses the working directory.
+func (fs *FilterSet) outputDir() (string, error) {
+	if fs.OutputFileName == "" || fs.OutputFileName == StdOutVal {
+		return os.Getwd()
+	}
+	return filepath.Dir(fs.OutputFileName), nil
+}
+
+// absPath resolves a path against the current output directory.
+func (fs *FilterSet) absPath(path string) (string, error) {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path), nil
+	}
+	dir, err := fs.outputDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, path), nil
+}
+
+func pathInput(sender string, in *pongo2.Value) (string, *pongo2.Error) {
+	if !in.IsString() {
+		return "", &pongo2.Error{
+			Sender:    sender,
+			OrigError: FilterError{Reason: "filter input must be of type 'string'."},
+		}
+	}
+	return in.String(), nil
+}
+
+// FilterBasename returns the last element of a path. An optional param is a suffix to remove,
+// such as basename:".conf".
+func (fs *FilterSet) FilterBasename(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	path, perr := pathInput("filter:basename", in)
+	if perr != nil {
+		return nil, perr
+	}
+	base := filepath.Base(path)
+	if !param.IsNil() {
+		base = strings.TrimSuffix(base, param.String())
+	}
+	return pongo2.AsValue(base), nil
+}
+
+// FilterDirname returns all but the last element of a path.
+func (fs *FilterSet) FilterDirname(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	path, perr := pathInput("filter:dirname", in)
+	if perr != nil {
+		return nil, perr
+	}
+	return pongo2.AsValue(filepath.Dir(path)), nil
+}
+
+// FilterPathJoin joins path elements. Usage: {{ dir|path_join:"file" }},
+// {{ dir|path_join:["sub", "file"] }} or {{ ["a", "b"]|path_join }} for a list input.
+func (fs *FilterSet) FilterPathJoin(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	var elements []string
+	appendElements := func(value *pongo2.Value) {
+		if !value.IsString() && value.CanSlice() {
+			for idx := 0; idx < value.Len(); idx++ {
+				elements = append(elements, value.Index(idx).String())
+			}
+			return
+		}
+		elements = append(elements, value.String())
+	}
+
+	appendElements(in)
+	if !param.IsNil() {
+		appendElements(param)
+	}
+	return pongo2.AsValue(filepath.Join(elements...)), nil
+}
+
+// FilterPathExt returns the file name extension of a path, including the dot.
+func (fs *FilterSet) FilterPathExt(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	path, perr := pathInput("filter:path_ext", in)
+	if perr != nil {
+		return nil, perr
+	}
+	return pongo2.AsValue(filepath.Ext(path)), nil
+}
+
+// FilterPathRel returns a path relative to the directory of the current output file, or
+// relative to the base path given as the param. Relative paths are resolved against the
+// output directory first.
+func (fs *FilterSet) FilterPathRel(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	path, perr := pathInput("filter:path_rel", in)
+	if perr != nil {
+		return nil, perr
+	}
+	target, err := fs.absPath(path)
+	if err != nil {
+		return nil, &pongo2.Error{Sender: "filter:path_rel", OrigError: err}
+	}
+
+	var base string
+	if param.IsNil() {
+		base, err = fs.outputDir()
+	} else {
+		base, err = fs.absPath(param.String())
+	}
+	if err != nil {
+		return nil, &pongo2.Error{Sender: "filter:path_rel", OrigError: err}
+	}
+
+	rel, err := filepath.Rel(base, target)
+	if err != nil {
+		return nil, &pongo2.Error{Sender: "filter:path_rel", OrigError: err}
+	}
+	return pongo2.AsValue(rel), nil
+}
+
+// FilterPathAbs returns the absolute form of a path, resolving relative paths against the
+// directory of the current output file.
+func (fs *FilterSet) FilterPathAbs(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	path, perr := pathInput("filter:path_abs", in)
+	if perr != nil {
+		return nil, perr
+	}
+	abs, err := fs.absPath(path)
+	if err != nil {
+		return nil, &pongo2.Error{Sender: "filter:path_abs", OrigError: err}
+	}
+	return pongo2.AsValue(abs), nil
+}
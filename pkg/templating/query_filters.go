@@ -0,0 +1,92 @@
+package templating
+
+import (
+	"math"
+	"reflect"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/jmespath/go-jmespath"
+)
+
+// queryData converts a value to the plain maps and slices JMESPath evaluates against. Maps with
+// non-string keys, such as those decoded from YAML, and typed slices are converted recursively,
+// and numbers are converted to float64.
+func queryData(v interface{}) interface{} {
+	if value, ok := v.(*pongo2.Value); ok {
+		return queryData(value.Interface())
+	}
+	if m, ok := asStringMap(v); ok {
+		result := make(map[string]interface{}, len(m))
+		for k, elem := range m {
+			result[k] = queryData(elem)
+		}
+		return result
+	}
+	if s, ok := asSlice(v); ok {
+		result := make([]interface{}, len(s))
+		for idx, elem := range s {
+			result[idx] = queryData(elem)
+		}
+		return result
+	}
+	// JMESPath only compares and sums float64 numbers, but YAML input decodes integers as int.
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() { //nolint:exhaustive
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint())
+	case reflect.Float32:
+		return rv.Float()
+	default:
+		return v
+	}
+}
+
+// maxExactInt is the largest integer every smaller integer of which a float64 represents exactly.
+const maxExactInt = 1 << 53
+
+// queryResult converts the integral float64 numbers JMESPath produces, such as from length(),
+// to ints so they render without a fractional part.
+func queryResult(v interface{}) interface{} {
+	switch value := v.(type) {
+	case float64:
+		if value == math.Trunc(value) && math.Abs(value) <= maxExactInt {
+			return int(value)
+		}
+		return value
+	case map[string]interface{}:
+		for k, elem := range value {
+			value[k] = queryResult(elem)
+		}
+		return value
+	case []interface{}:
+		for idx, elem := range value {
+			value[idx] = queryResult(elem)
+		}
+		return value
+	default:
+		return v
+	}
+}
+
+// FilterQuery evaluates a JMESPath expression against its input, e.g.
+// {{ cluster|query:"nodes[?role=='master'].ip" }}.
+func (fs *FilterSet) FilterQuery(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	if !param.IsString() {
+		return nil, &pongo2.Error{
+			Sender:    "filter:query",
+			OrigError: FilterError{Reason: "filter param must be a JMESPath expression string."},
+		}
+	}
+
+	expression, err := jmespath.Compile(param.String())
+	if err != nil {
+		return nil, &pongo2.Error{Sender: "filter:query", OrigError: err}
+	}
+	result, err := expression.Search(queryData(in.Interface()))
+	if err != nil {
+		return nil, &pongo2.Error{Sender: "filter:query", OrigError: err}
+	}
+	return pongo2.AsValue(queryResult(result)), nil
+}
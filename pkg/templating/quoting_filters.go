@@ -0,0 +1,172 @@
+package templating
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/kballard/go-shellquote"
+	"gopkg.in/yaml.v3"
+)
+
+// The quoting filters return safe values, since HTML escaping by --autoescape would corrupt
+// output quoted for another language.
+
+//nolint:gochecknoglobals
+var (
+	xmlEscaper   = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&apos;")
+	nginxEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// FilterShellQuote quotes its input for use as a single POSIX shell word. A list input is
+// quoted element-wise and joined with spaces.
+func (fs *FilterSet) FilterShellQuote(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	if !in.IsString() && in.CanSlice() {
+		words := make([]string, 0, in.Len())
+		for idx := 0; idx < in.Len(); idx++ {
+			words = append(words, in.Index(idx).String())
+		}
+		return pongo2.AsSafeValue(shellquote.Join(words...)), nil
+	}
+	return pongo2.AsSafeValue(shellquote.Join(in.String())), nil
+}
+
+// systemdEscapeName escapes a string as a systemd unit name component, as systemd-escape does.
+func systemdEscapeName(value string) string {
+	out := new(strings.Builder)
+	for idx := 0; idx < len(value); idx++ {
+		c := value[idx]
+		switch {
+		case c == '/':
+			out.WriteByte('-')
+		case c == '.' && idx == 0,
+			!(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == ':' || c == '_' || c == '.'):
+			fmt.Fprintf(out, `\x%02x`, c)
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String()
+}
+
+// systemdQuoteValue quotes a string for use as a value in a unit file directive. Specifiers
+// (%) are escaped so the value is used literally. Environment variable expansion ($) is only
+// escaped when exec is set, since only command lines such as ExecStart= expand it.
+func systemdQuoteValue(value string, exec bool) string {
+	out := new(strings.Builder)
+	out.WriteByte('"')
+	for _, r := range value {
+		switch r {
+		case '"', '\\':
+			out.WriteByte('\\')
+			out.WriteRune(r)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\t':
+			out.WriteString(`\t`)
+		case '\r':
+			out.WriteString(`\r`)
+		case '%':
+			out.WriteString("%%")
+		case '$':
+			if exec {
+				out.WriteString("$$")
+			} else {
+				out.WriteRune(r)
+			}
+		default:
+			if r < ' ' || r == 0x7f {
+				fmt.Fprintf(out, `\x%02x`, r)
+			} else {
+				out.WriteRune(r)
+			}
+		}
+	}
+	out.WriteByte('"')
+	return out.String()
+}
+
+// FilterSystemdEscape escapes its input for systemd. By default it escapes a unit name
+// component like systemd-escape. The param "path" escapes a path like systemd-escape --path,
+// "value" quotes a string for use as a directive value such as Environment=, and "exec"
+// quotes a command line argument for ExecStart= and similar directives.
+func (fs *FilterSet) FilterSystemdEscape(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	mode := "name"
+	if !param.IsNil() {
+		mode = param.String()
+	}
+
+	value := in.String()
+	switch mode {
+	case "name":
+		if value == "" {
+			return nil, &pongo2.Error{
+				Sender:    "filter:systemd_escape",
+				OrigError: FilterError{Reason: "cannot escape an empty unit name."},
+			}
+		}
+		return pongo2.AsSafeValue(systemdEscapeName(value)), nil
+	case "path":
+		var parts []string
+		for _, part := range strings.Split(value, "/") {
+			if part != "" && part != "." {
+				parts = append(parts, part)
+			}
+		}
+		if len(parts) == 0 {
+			return pongo2.AsSafeValue("-"), nil
+		}
+		return pongo2.AsSafeValue(systemdEscapeName(strings.Join(parts, "/"))), nil
+	case "value":
+		return pongo2.AsSafeValue(systemdQuoteValue(value, false)), nil
+	case "exec":
+		return pongo2.AsSafeValue(systemdQuoteValue(value, true)), nil
+	default:
+		return nil, &pongo2.Error{
+			Sender:    "filter:systemd_escape",
+			OrigError: FilterError{Reason: "filter param must be 'name', 'path', 'value' or 'exec'."},
+		}
+	}
+}
+
+// FilterNginxQuote quotes its input as a double quoted nginx configuration string. Note nginx
+// has no escape for "$", which still introduces a variable in directives that support them.
+func (fs *FilterSet) FilterNginxQuote(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	return pongo2.AsSafeValue(`"` + nginxEscaper.Replace(in.String()) + `"`), nil
+}
+
+// FilterJSONString encodes its input as a quoted JSON string.
+func (fs *FilterSet) FilterJSONString(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	buf := new(bytes.Buffer)
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(in.String()); err != nil {
+		return nil, &pongo2.Error{Sender: "filter:json_string", OrigError: err}
+	}
+	return pongo2.AsSafeValue(strings.TrimSuffix(buf.String(), "\n")), nil
+}
+
+// FilterXMLEscape escapes the XML special characters in its input, so the result is safe in
+// both element content and quoted attribute values.
+func (fs *FilterSet) FilterXMLEscape(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	return pongo2.AsSafeValue(xmlEscaper.Replace(in.String())), nil
+}
+
+// FilterYAMLQuote encodes its input as a double quoted YAML scalar, so it is always read back
+// as a string.
+func (fs *FilterSet) FilterYAMLQuote(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	node := &yaml.Node{Kind: yaml.ScalarNode, Style: yaml.DoubleQuotedStyle, Tag: "!!str", Value: in.String()}
+	b, err := yaml.Marshal(node)
+	if err != nil {
+		return nil, &pongo2.Error{Sender: "filter:yaml_quote", OrigError: err}
+	}
+	return pongo2.AsSafeValue(strings.TrimSuffix(string(b), "\n")), nil
+}
+
+// FilterRegexEscape escapes all regular expression metacharacters in its input.
+func (fs *FilterSet) FilterRegexEscape(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	return pongo2.AsSafeValue(regexp.QuoteMeta(in.String())), nil
+}
@@ -0,0 +1,332 @@
+package templating
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/google/uuid"
+)
+
+const (
+	randomStringDefaultLength = 16
+	passwordDefaultLength     = 24
+	passwordMinLength         = 4
+	passwordSymbols           = "!#%+,-.:=@^_~"
+)
+
+//nolint:gochecknoglobals
+var randomCharsets = map[string]string{
+	"alnum":  "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789",
+	"alpha":  "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+	"lower":  "abcdefghijklmnopqrstuvwxyz",
+	"upper":  "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"digits": "0123456789",
+	"hex":    "0123456789abcdef",
+}
+
+// uuidNamespace is the namespace of UUIDs generated from a seed by the uuid filter.
+//
+//nolint:gochecknoglobals
+var uuidNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://github.com/wrouesnel/p2cli"))
+
+// seededReader is an endless deterministic byte stream derived from a seed. Each filter uses
+// its own stream so the same seed gives unrelated output in different filters.
+type seededReader struct {
+	key     []byte
+	label   string
+	counter uint64
+	buf     []byte
+}
+
+func newSeededReader(label string, seed string) *seededReader {
+	return &seededReader{key: []byte(seed), label: label}
+}
+
+func (r *seededReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(r.buf) == 0 {
+			mac := hmac.New(sha256.New, r.key)
+			_, _ = mac.Write([]byte(r.label))
+			_ = binary.Write(mac, binary.BigEndian, r.counter)
+			r.buf = mac.Sum(nil)
+			r.counter++
+		}
+		copied := copy(p[n:], r.buf)
+		r.buf = r.buf[copied:]
+		n += copied
+	}
+	return n, nil
+}
+
+// randomSource returns the byte stream a generator filter reads from. The filter input is the
+// seed. An empty seed yields crypto/rand output, but only when AllowRandom is set since the
+// result then changes on every render. Any params are mixed into the stream so that calls
+// which differ only in them give unrelated output from the same seed.
+func (fs *FilterSet) randomSource(sender string, in *pongo2.Value, params ...string) (io.Reader, string, *pongo2.Error) {
+	seed := ""
+	if !in.IsNil() {
+		seed = in.String()
+	}
+	if seed != "" {
+		label := sender
+		for _, param := range params {
+			label += "\x00" + param
+		}
+		return newSeededReader(label, seed), seed, nil
+	}
+	if !fs.AllowRandom {
+		return nil, "", &pongo2.Error{
+			Sender:    sender,
+			OrigError: FilterError{Reason: "filter input must be a non-empty seed (use --allow-random for non-deterministic output)."},
+		}
+	}
+	return rand.Reader, "", nil
+}
+
+// uniformInt returns a uniformly distributed integer in [0, n) read from r.
+func uniformInt(r io.Reader, n uint64) (uint64, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	// Reject values from the incomplete final interval to avoid modulo bias.
+	limit := math.MaxUint64 - math.MaxUint64%n
+	var b [8]byte
+	for {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, fmt.Errorf("could not read random data: %w", err)
+		}
+		v := binary.BigEndian.Uint64(b[:])
+		if v < limit {
+			return v % n, nil
+		}
+	}
+}
+
+func randomChars(r io.Reader, charset string, length int) (string, error) {
+	// Choose characters rather than bytes so multi-byte charsets give valid UTF-8.
+	chars := []rune(charset)
+	result := make([]rune, length)
+	for idx := range result {
+		pos, err := uniformInt(r, uint64(len(chars)))
+		if err != nil {
+			return "", err
+		}
+		result[idx] = chars[pos]
+	}
+	return string(result), nil
+}
+
+// intParams parses a filter param consisting of an integer or a set of integers, followed
+// by up to maxStrings string elements.
+func intParams(sender string, param *pongo2.Value, maxInts int, maxStrings int) ([]int, []string, *pongo2.Error) {
+	var raw []string
+	switch {
+	case param.IsNil():
+	case param.IsInteger():
+		raw = append(raw, strconv.Itoa(param.Integer()))
+	case param.IsString():
+		raw = append(raw, param.String())
+	case param.CanSlice():
+		for idx := 0; idx < param.Len(); idx++ {
+			// Set notation returns strings
+			raw = append(raw, param.Index(idx).String())
+		}
+	}
+	if len(raw) > maxInts+maxStrings {
+		return nil, nil, &pongo2.Error{
+			Sender:    sender,
+			OrigError: FilterError{Reason: fmt.Sprintf("filter param accepts at most %d elements", maxInts+maxStrings)},
+		}
+	}
+
+	ints := make([]int, 0, maxInts)
+	for idx := 0; idx < len(raw) && idx < maxInts; idx++ {
+		value, err := strconv.Atoi(raw[idx])
+		if err != nil {
+			return nil, nil, &pongo2.Error{
+				Sender:    sender,
+				OrigError: FilterError{Reason: fmt.Sprintf("element %d of filter param must be an integer", idx)},
+			}
+		}
+		ints = append(ints, value)
+	}
+	var strs []string
+	if len(raw) > maxInts {
+		strs = raw[maxInts:]
+	}
+	return ints, strs, nil
+}
+
+// FilterUUID outputs a UUID. A seed input gives a name-based (version 5) UUID, so the same
+// seed always gives the same UUID. An alternative namespace UUID may be given as the param.
+func (fs *FilterSet) FilterUUID(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	source, seed, perr := fs.randomSource("filter:uuid", in)
+	if perr != nil {
+		return nil, perr
+	}
+
+	namespace := uuidNamespace
+	if !param.IsNil() {
+		parsed, err := uuid.Parse(param.String())
+		if err != nil {
+			return nil, &pongo2.Error{
+				Sender:    "filter:uuid",
+				OrigError: FilterError{Reason: "filter param must be a namespace UUID."},
+			}
+		}
+		namespace = parsed
+	}
+
+	if seed != "" {
+		return pongo2.AsValue(uuid.NewSHA1(namespace, []byte(seed)).String()), nil
+	}
+	result, err := uuid.NewRandomFromReader(source)
+	if err != nil {
+		return nil, &pongo2.Error{Sender: "filter:uuid", OrigError: err}
+	}
+	return pongo2.AsValue(result.String()), nil
+}
+
+// FilterRandomString outputs a string of random characters. Usage: random_string:length or
+// random_string:[length, charset]. charset is one of alnum (the default), alpha, lower,
+// upper, digits or hex, or otherwise the literal characters to choose from.
+func (fs *FilterSet) FilterRandomString(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	ints, strs, perr := intParams("filter:random_string", param, 1, 1)
+	if perr != nil {
+		return nil, perr
+	}
+
+	length := randomStringDefaultLength
+	if len(ints) > 0 {
+		length = ints[0]
+	}
+	charset := randomCharsets["alnum"]
+	if len(strs) > 0 {
+		if named, ok := randomCharsets[strs[0]]; ok {
+			charset = named
+		} else {
+			charset = strs[0]
+		}
+	}
+	if length < 0 || charset == "" {
+		return nil, &pongo2.Error{
+			Sender:    "filter:random_string",
+			OrigError: FilterError{Reason: "length must not be negative and charset must not be empty."},
+		}
+	}
+	// The length and charset are mixed into the stream, or else a shorter string would be a
+	// prefix of a longer one from the same seed.
+	source, _, perr := fs.randomSource("filter:random_string", in, strconv.Itoa(length), charset)
+	if perr != nil {
+		return nil, perr
+	}
+
+	result, err := randomChars(source, charset, length)
+	if err != nil {
+		return nil, &pongo2.Error{Sender: "filter:random_string", OrigError: err}
+	}
+	return pongo2.AsValue(result), nil
+}
+
+// FilterRandomInt outputs a random integer. Usage: random_int:max or random_int:[min, max].
+// Both bounds are inclusive and min defaults to 0.
+//
+//nolint:mnd
+func (fs *FilterSet) FilterRandomInt(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	ints, _, perr := intParams("filter:random_int", param, 2, 0)
+	if perr != nil {
+		return nil, perr
+	}
+
+	var lower, upper int
+	switch len(ints) {
+	case 1:
+		upper = ints[0]
+	case 2:
+		lower, upper = ints[0], ints[1]
+	default:
+		return nil, &pongo2.Error{
+			Sender:    "filter:random_int",
+			OrigError: FilterError{Reason: "filter param must be a maximum, or a set of minimum and maximum."},
+		}
+	}
+	if upper < lower {
+		return nil, &pongo2.Error{
+			Sender:    "filter:random_int",
+			OrigError: FilterError{Reason: "maximum must not be less than minimum."},
+		}
+	}
+
+	// The span is computed unsigned so bounds of opposite sign cannot overflow. The full int
+	// range has no representable number of values.
+	span := uint64(upper) - uint64(lower) //nolint:gosec
+	if span == math.MaxUint64 {
+		return nil, &pongo2.Error{
+			Sender:    "filter:random_int",
+			OrigError: FilterError{Reason: "range must not span every integer."},
+		}
+	}
+	source, _, perr := fs.randomSource("filter:random_int", in, strconv.Itoa(lower), strconv.Itoa(upper))
+	if perr != nil {
+		return nil, perr
+	}
+	offset, err := uniformInt(source, span+1)
+	if err != nil {
+		return nil, &pongo2.Error{Sender: "filter:random_int", OrigError: err}
+	}
+	return pongo2.AsValue(lower + int(offset)), nil //nolint:gosec
+}
+
+// FilterPassword outputs a random password containing at least one upper case letter, lower
+// case letter, digit and symbol. Usage: password or password:length (default 24).
+func (fs *FilterSet) FilterPassword(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	ints, _, perr := intParams("filter:password", param, 1, 0)
+	if perr != nil {
+		return nil, perr
+	}
+
+	length := passwordDefaultLength
+	if len(ints) > 0 {
+		length = ints[0]
+	}
+	if length < passwordMinLength {
+		return nil, &pongo2.Error{
+			Sender:    "filter:password",
+			OrigError: FilterError{Reason: fmt.Sprintf("password length must be at least %d.", passwordMinLength)},
+		}
+	}
+	source, _, perr := fs.randomSource("filter:password", in, strconv.Itoa(length))
+	if perr != nil {
+		return nil, perr
+	}
+
+	classes := []string{randomCharsets["upper"], randomCharsets["lower"], randomCharsets["digits"], passwordSymbols}
+	charset := strings.Join(classes, "")
+	for {
+		result, err := randomChars(source, charset, length)
+		if err != nil {
+			return nil, &pongo2.Error{Sender: "filter:password", OrigError: err}
+		}
+		// Draw again until every class is present. The stream continues, so a seeded
+		// password remains deterministic.
+		complete := true
+		for _, class := range classes {
+			if !strings.ContainsAny(result, class) {
+				complete = false
+				break
+			}
+		}
+		if complete {
+			return pongo2.AsValue(result), nil
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package templating
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/flosch/pongo2/v6"
+)
+
+// regexParams extracts a compiled pattern and any further set elements from a filter param.
+// The param may be a plain pattern string, or a set whose first element is the pattern.
+func regexParams(sender string, param *pongo2.Value, maxExtra int) (*regexp.Regexp, []string, *pongo2.Error) {
+	var pattern string
+	var extra []string
+
+	switch {
+	case param.IsString():
+		pattern = param.String()
+	case param.CanSlice() && param.Len() >= 1 && param.Len() <= maxExtra+1:
+		pattern = param.Index(0).String()
+		for idx := 1; idx < param.Len(); idx++ {
+			extra = append(extra, param.Index(idx).String())
+		}
+	default:
+		return nil, nil, &pongo2.Error{
+			Sender:    sender,
+			OrigError: FilterError{Reason: "filter param must be a pattern string, or a set starting with a pattern string."},
+		}
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, nil, &pongo2.Error{
+			Sender:    sender,
+			OrigError: err,
+		}
+	}
+	return re, extra, nil
+}
+
+// regexCount parses an optional count element from set notation, which returns strings.
+// A missing count means unlimited (-1).
+func regexCount(sender string, extra []string, idx int) (int, *pongo2.Error) {
+	if len(extra) <= idx {
+		return -1, nil
+	}
+	count, err := strconv.Atoi(extra[idx])
+	if err != nil {
+		return 0, &pongo2.Error{
+			Sender:    sender,
+			OrigError: FilterError{Reason: "count element of filter param must be an integer"},
+		}
+	}
+	return count, nil
+}
+
+func regexInput(sender string, in *pongo2.Value) (string, *pongo2.Error) {
+	if !in.IsString() {
+		return "", &pongo2.Error{
+			Sender:    sender,
+			OrigError: FilterError{Reason: "filter input must be of type 'string'."},
+		}
+	}
+	return in.String(), nil
+}
+
+// FilterRegexMatch returns true if the input matches the pattern anywhere.
+func (fs *FilterSet) FilterRegexMatch(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	input, perr := regexInput("filter:regex_match", in)
+	if perr != nil {
+		return nil, perr
+	}
+	re, _, perr := regexParams("filter:regex_match", param, 0)
+	if perr != nil {
+		return nil, perr
+	}
+	return pongo2.AsValue(re.MatchString(input)), nil
+}
+
+// FilterRegexReplace replaces matches of a pattern. Usage: ["pattern", "replacement"] with an
+// optional third count element. The replacement may reference capture groups as $1 or ${name}.
+//
+//nolint:mnd
+func (fs *FilterSet) FilterRegexReplace(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	input, perr := regexInput("filter:regex_replace", in)
+	if perr != nil {
+		return nil, perr
+	}
+	if !param.CanSlice() || param.IsString() || param.Len() < 2 {
+		return nil, &pongo2.Error{
+			Sender:    "filter:regex_replace",
+			OrigError: FilterError{Reason: "filter param must be of type 'slice' containing 2 strings or 2 strings and int."},
+		}
+	}
+	re, extra, perr := regexParams("filter:regex_replace", param, 2)
+	if perr != nil {
+		return nil, perr
+	}
+	replacement := extra[0]
+	count, perr := regexCount("filter:regex_replace", extra, 1)
+	if perr != nil {
+		return nil, perr
+	}
+
+	if count < 0 {
+		return pongo2.AsValue(re.ReplaceAllString(input, replacement)), nil
+	}
+
+	result := []byte{}
+	lastEnd := 0
+	for _, match := range re.FindAllStringSubmatchIndex(input, count) {
+		result = append(result, input[lastEnd:match[0]]...)
+		result = re.ExpandString(result, replacement, input, match)
+		lastEnd = match[1]
+	}
+	result = append(result, input[lastEnd:]...)
+
+	return pongo2.AsValue(string(result)), nil
+}
+
+// FilterRegexFindall returns a list of all matches of the pattern. If the pattern has a single
+// capture group, the list contains the group values, and if it has several each element is
+// a list of the group values. An optional second set element limits the number of matches.
+func (fs *FilterSet) FilterRegexFindall(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	input, perr := regexInput("filter:regex_findall", in)
+	if perr != nil {
+		return nil, perr
+	}
+	re, extra, perr := regexParams("filter:regex_findall", param, 1)
+	if perr != nil {
+		return nil, perr
+	}
+	count, perr := regexCount("filter:regex_findall", extra, 0)
+	if perr != nil {
+		return nil, perr
+	}
+
+	matches := re.FindAllStringSubmatch(input, count)
+	result := make([]interface{}, 0, len(matches))
+	for _, match := range matches {
+		switch len(match) {
+		case 1:
+			result = append(result, match[0])
+		case 2: //nolint:mnd
+			result = append(result, match[1])
+		default:
+			groups := make([]interface{}, 0, len(match)-1)
+			for _, group := range match[1:] {
+				groups = append(groups, group)
+			}
+			result = append(result, groups)
+		}
+	}
+	return pongo2.AsValue(result), nil
+}
+
+// FilterRegexSplit splits the input around matches of the pattern. An optional second set
+// element limits the number of substrings returned.
+func (fs *FilterSet) FilterRegexSplit(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	input, perr := regexInput("filter:regex_split", in)
+	if perr != nil {
+		return nil, perr
+	}
+	re, extra, perr := regexParams("filter:regex_split", param, 1)
+	if perr != nil {
+		return nil, perr
+	}
+	count, perr := regexCount("filter:regex_split", extra, 0)
+	if perr != nil {
+		return nil, perr
+	}
+
+	return pongo2.AsValue(re.Split(input, count)), nil
+}
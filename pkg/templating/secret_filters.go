@@ -0,0 +1,14 @@
+package templating
+
+import (
+	"github.com/flosch/pongo2/v6"
+)
+
+// FilterSecret marks its input as sensitive so it is masked wherever it appears in logs, and
+// returns it unchanged. Maps and lists mark every value they contain.
+func (fs *FilterSet) FilterSecret(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	if fs.Redactor != nil {
+		fs.Redactor.AddValue(in.Interface())
+	}
+	return in, nil
+}
@@ -0,0 +1,104 @@
+package templating
+
+import (
+	"github.com/Masterminds/semver/v3"
+	"github.com/flosch/pongo2/v6"
+)
+
+// versionValue parses a filter value as a semantic version. Versions are parsed leniently, so
+// "v1.2" and "1.25" are accepted as 1.2.0 and 1.25.0.
+func versionValue(sender string, value *pongo2.Value) (*semver.Version, *pongo2.Error) {
+	if value.IsNil() {
+		return nil, &pongo2.Error{
+			Sender:    sender,
+			OrigError: FilterError{Reason: "a version is required."},
+		}
+	}
+	version, err := semver.NewVersion(value.String())
+	if err != nil {
+		return nil, &pongo2.Error{Sender: sender, OrigError: err}
+	}
+	return version, nil
+}
+
+// FilterSemverParse parses a semantic version into a map of its components: major, minor,
+// patch, prerelease, metadata and the normalized version string.
+func (fs *FilterSet) FilterSemverParse(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	version, perr := versionValue("filter:semver_parse", in)
+	if perr != nil {
+		return nil, perr
+	}
+	return pongo2.AsValue(map[string]interface{}{
+		"major":      version.Major(),
+		"minor":      version.Minor(),
+		"patch":      version.Patch(),
+		"prerelease": version.Prerelease(),
+		"metadata":   version.Metadata(),
+		"version":    version.String(),
+	}), nil
+}
+
+// FilterSemverCompare compares the input version to the param version, returning -1, 0 or 1 if
+// the input is less than, equal to or greater than the param.
+func (fs *FilterSet) FilterSemverCompare(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	version, perr := versionValue("filter:semver_compare", in)
+	if perr != nil {
+		return nil, perr
+	}
+	other, perr := versionValue("filter:semver_compare", param)
+	if perr != nil {
+		return nil, perr
+	}
+	return pongo2.AsValue(version.Compare(other)), nil
+}
+
+// FilterSemverSatisfies returns true if the input version satisfies the constraint param, such
+// as ">= 1.25", "~1.2" or ">= 1.0, < 2.0 || >= 3.0".
+func (fs *FilterSet) FilterSemverSatisfies(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	version, perr := versionValue("filter:semver_satisfies", in)
+	if perr != nil {
+		return nil, perr
+	}
+	if !param.IsString() {
+		return nil, &pongo2.Error{
+			Sender:    "filter:semver_satisfies",
+			OrigError: FilterError{Reason: "filter param must be a constraint string."},
+		}
+	}
+	constraint, err := semver.NewConstraint(param.String())
+	if err != nil {
+		return nil, &pongo2.Error{Sender: "filter:semver_satisfies", OrigError: err}
+	}
+	return pongo2.AsValue(constraint.Check(version)), nil
+}
+
+// FilterSemverBump increments the "major", "minor" or "patch" (the default) component of a
+// version. Lower components are reset to zero and any prerelease or metadata is dropped.
+func (fs *FilterSet) FilterSemverBump(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	version, perr := versionValue("filter:semver_bump", in)
+	if perr != nil {
+		return nil, perr
+	}
+
+	component := "patch"
+	if !param.IsNil() {
+		component = param.String()
+	}
+
+	var bumped semver.Version
+	switch component {
+	case "major":
+		bumped = version.IncMajor()
+	case "minor":
+		bumped = version.IncMinor()
+	case "patch":
+		// IncPatch only drops a prerelease without incrementing, unlike IncMajor and IncMinor.
+		bumped = *semver.New(version.Major(), version.Minor(), version.Patch()+1, "", "")
+	default:
+		return nil, &pongo2.Error{
+			Sender:    "filter:semver_bump",
+			OrigError: FilterError{Reason: "filter param must be 'major', 'minor' or 'patch'."},
+		}
+	}
+	return pongo2.AsValue(bumped.String()), nil
+}
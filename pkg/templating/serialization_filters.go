@@ -0,0 +1,634 @@
+package templating
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/kballard/go-shellquote"
+)
+
+const (
+	quoteAuto   = "auto"
+	quoteAlways = "always"
+	quoteNever  = "never"
+
+	sortAsc  = "asc"
+	sortDesc = "desc"
+
+	// iniReservedChars may not appear in INI section names or keys, since they delimit
+	// sections, keys, values and comments.
+	iniReservedChars = "[]=;#\n\r"
+)
+
+//nolint:gochecknoglobals
+var (
+	reHCLIdentifier = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_-]*$`)
+	reEnvName       = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// reXMLName approximates the XML Name production. Names with a ":" are rejected since
+	// the filter does not declare namespaces.
+	reXMLName = regexp.MustCompile(`^[\p{L}_][\p{L}\p{Mn}\p{Mc}\p{Nd}_.\-]*$`)
+)
+
+// serializerOptions are the options common to the to_* serialization filters. They are
+// supplied as a single "key=value" string, or a set of them (e.g. ["sort=desc", "export"]).
+type serializerOptions struct {
+	Sort  string
+	Quote string
+	Flags map[string]string
+}
+
+func parseSerializerOptions(sender string, param *pongo2.Value) (serializerOptions, *pongo2.Error) {
+	opts := serializerOptions{Sort: sortAsc, Quote: quoteAuto, Flags: map[string]string{}}
+
+	var raw []string
+	switch {
+	case param.IsNil():
+	case param.IsString():
+		if param.String() != "" {
+			raw = append(raw, param.String())
+		}
+	case param.CanSlice():
+		for idx := 0; idx < param.Len(); idx++ {
+			raw = append(raw, param.Index(idx).String())
+		}
+	default:
+		return opts, &pongo2.Error{
+			Sender:    sender,
+			OrigError: FilterError{Reason: "filter param must be a string or set of strings."},
+		}
+	}
+
+	const expectedFragments = 2
+	for _, option := range raw {
+		keyval := strings.SplitN(option, "=", expectedFragments)
+		key := strings.TrimSpace(keyval[0])
+		value := ""
+		if len(keyval) == expectedFragments {
+			value = strings.TrimSpace(keyval[1])
+		}
+
+		switch key {
+		case "sort":
+			if value != sortAsc && value != sortDesc {
+				return opts, &pongo2.Error{
+					Sender:    sender,
+					OrigError: FilterError{Reason: "sort option must be 'asc' or 'desc'."},
+				}
+			}
+			opts.Sort = value
+		case "quote":
+			if value != quoteAuto && value != quoteAlways && value != quoteNever {
+				return opts, &pongo2.Error{
+					Sender:    sender,
+					OrigError: FilterError{Reason: "quote option must be 'auto', 'always' or 'never'."},
+				}
+			}
+			opts.Quote = value
+		default:
+			opts.Flags[key] = value
+		}
+	}
+
+	return opts, nil
+}
+
+// flag returns the value of an option flag, or def if it was not supplied.
+func (o serializerOptions) flag(name string, def string) string {
+	if value, ok := o.Flags[name]; ok {
+		return value
+	}
+	return def
+}
+
+// sortedKeys returns the keys of a map in the order requested by the options.
+func (o serializerOptions) sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if o.Sort == sortDesc {
+		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	}
+	return keys
+}
+
+// asStringMap converts any map type (such as the map[interface{}]interface{} produced by
+// YAML input) to a map[string]interface{}.
+func asStringMap(v interface{}) (map[string]interface{}, bool) {
+	if m, ok := v.(map[string]interface{}); ok {
+		return m, true
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Map {
+		return nil, false
+	}
+
+	result := make(map[string]interface{}, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		result[fmt.Sprint(iter.Key().Interface())] = iter.Value().Interface()
+	}
+	return result, true
+}
+
+// asSlice converts any slice or array (other than []byte) to a []interface{}.
+func asSlice(v interface{}) ([]interface{}, bool) {
+	if _, ok := v.([]byte); ok {
+		return nil, false
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, false
+	}
+
+	result := make([]interface{}, rv.Len())
+	for idx := 0; idx < rv.Len(); idx++ {
+		result[idx] = rv.Index(idx).Interface()
+	}
+	return result, true
+}
+
+// scalarString formats a scalar value for output.
+func scalarString(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(value), 'f', -1, 32)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// flatten converts nested maps and lists into a single level map with joined keys.
+func flatten(prefix string, separator string, v interface{}, result map[string]interface{}) {
+	if m, ok := asStringMap(v); ok {
+		for k, elem := range m {
+			key := k
+			if prefix != "" {
+				key = prefix + separator + k
+			}
+			flatten(key, separator, elem, result)
+		}
+		return
+	}
+
+	if s, ok := asSlice(v); ok {
+		for idx, elem := range s {
+			flatten(fmt.Sprintf("%s%s%d", prefix, separator, idx), separator, elem, result)
+		}
+		return
+	}
+
+	result[prefix] = v
+}
+
+func serializerInputMap(sender string, in *pongo2.Value) (map[string]interface{}, *pongo2.Error) {
+	m, ok := asStringMap(in.Interface())
+	if !ok {
+		return nil, &pongo2.Error{
+			Sender:    sender,
+			OrigError: FilterError{Reason: "filter input must be a map."},
+		}
+	}
+	return m, nil
+}
+
+func shellSingleQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// FilterToEnv outputs a map as a dotenv file of KEY=value lines. Nested keys are joined with
+// "_" (change with "separator=") and values are shell-quoted. The "export" flag prefixes each
+// line with "export ", and "upper" upper-cases the keys.
+func (fs *FilterSet) FilterToEnv(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	opts, perr := parseSerializerOptions("filter:to_env", param)
+	if perr != nil {
+		return nil, perr
+	}
+	m, perr := serializerInputMap("filter:to_env", in)
+	if perr != nil {
+		return nil, perr
+	}
+
+	flat := make(map[string]interface{})
+	flatten("", opts.flag("separator", "_"), m, flat)
+
+	_, export := opts.Flags["export"]
+	// Keys are upper-cased before sorting, so the output is in the order of the keys written.
+	if _, upper := opts.Flags["upper"]; upper {
+		upperFlat := make(map[string]interface{}, len(flat))
+		for key, value := range flat {
+			key = strings.ToUpper(key)
+			if _, found := upperFlat[key]; found {
+				return nil, &pongo2.Error{
+					Sender:    "filter:to_env",
+					OrigError: FilterError{Reason: fmt.Sprintf("keys collide when upper-cased: %q", key)},
+				}
+			}
+			upperFlat[key] = value
+		}
+		flat = upperFlat
+	}
+
+	buf := new(bytes.Buffer)
+	for _, key := range opts.sortedKeys(flat) {
+		value := scalarString(flat[key])
+		switch opts.Quote {
+		case quoteAlways:
+			value = shellSingleQuote(value)
+		case quoteNever:
+		default:
+			value = shellquote.Join(value)
+		}
+
+		if !reEnvName.MatchString(key) {
+			return nil, &pongo2.Error{
+				Sender:    "filter:to_env",
+				OrigError: FilterError{Reason: fmt.Sprintf("key is not a valid environment variable name: %q", key)},
+			}
+		}
+		if export {
+			buf.WriteString("export ")
+		}
+		fmt.Fprintf(buf, "%s=%s\n", key, value)
+	}
+
+	return pongo2.AsValue(buf.String()), nil
+}
+
+// escapeProperties escapes a Java properties key or value. Non-ASCII characters are
+// escaped as \uXXXX when asciiOnly is set.
+func escapeProperties(s string, isKey bool, asciiOnly bool) string {
+	buf := new(strings.Builder)
+	for idx, r := range s {
+		switch {
+		case r == '\\':
+			buf.WriteString(`\\`)
+		case r == '\n':
+			buf.WriteString(`\n`)
+		case r == '\r':
+			buf.WriteString(`\r`)
+		case r == '\t':
+			buf.WriteString(`\t`)
+		case r == '\f':
+			buf.WriteString(`\f`)
+		case r == ' ' && (isKey || idx == 0):
+			buf.WriteString(`\ `)
+		case (r == '=' || r == ':') && isKey, (r == '#' || r == '!') && (isKey || idx == 0):
+			buf.WriteRune('\\')
+			buf.WriteRune(r)
+		case asciiOnly && r > unicode.MaxASCII:
+			for _, unit := range utf16.Encode([]rune{r}) {
+				fmt.Fprintf(buf, `\u%04x`, unit)
+			}
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
+
+// FilterToProperties outputs a map as a Java properties file. Nested keys are joined with
+// "." (change with "separator="). quote=always escapes all non-ASCII characters, and
+// quote=never writes keys and values unescaped.
+func (fs *FilterSet) FilterToProperties(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	opts, perr := parseSerializerOptions("filter:to_properties", param)
+	if perr != nil {
+		return nil, perr
+	}
+	m, perr := serializerInputMap("filter:to_properties", in)
+	if perr != nil {
+		return nil, perr
+	}
+
+	flat := make(map[string]interface{})
+	flatten("", opts.flag("separator", "."), m, flat)
+
+	buf := new(bytes.Buffer)
+	for _, key := range opts.sortedKeys(flat) {
+		value := scalarString(flat[key])
+		if opts.Quote != quoteNever {
+			asciiOnly := opts.Quote == quoteAlways
+			key = escapeProperties(key, true, asciiOnly)
+			value = escapeProperties(value, false, asciiOnly)
+		}
+		fmt.Fprintf(buf, "%s=%s\n", key, value)
+	}
+
+	return pongo2.AsValue(buf.String()), nil
+}
+
+// escapeINIValue double quotes an INI value, escaping backslashes, double quotes and line
+// breaks as git config style escapes.
+func escapeINIValue(value string) string {
+	buf := new(strings.Builder)
+	buf.WriteByte('"')
+	for _, r := range value {
+		switch r {
+		case '\\':
+			buf.WriteString(`\\`)
+		case '"':
+			buf.WriteString(`\"`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// checkININame returns an error if an INI section name or key contains a reserved character.
+func checkININame(kind string, name string) *pongo2.Error {
+	if strings.ContainsAny(name, iniReservedChars) {
+		return &pongo2.Error{
+			Sender:    "filter:to_ini",
+			OrigError: FilterError{Reason: fmt.Sprintf("%s may not contain any of '[', ']', '=', ';', '#' or a newline: %q", kind, name)},
+		}
+	}
+	return nil
+}
+
+func quoteINIValue(value string, quote string) string {
+	switch quote {
+	case quoteAlways:
+		return escapeINIValue(value)
+	case quoteNever:
+		return value
+	default:
+		if value != strings.TrimSpace(value) || strings.ContainsAny(value, ";#=\"\\\n\r\t") {
+			return escapeINIValue(value)
+		}
+		return value
+	}
+}
+
+// FilterToINI outputs a map as an INI file. Scalar values at the top level are written
+// before the first section, and map values become sections. Deeper nesting is flattened
+// into the section with "." joined keys.
+func (fs *FilterSet) FilterToINI(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	opts, perr := parseSerializerOptions("filter:to_ini", param)
+	if perr != nil {
+		return nil, perr
+	}
+	m, perr := serializerInputMap("filter:to_ini", in)
+	if perr != nil {
+		return nil, perr
+	}
+
+	globals := make(map[string]interface{})
+	sections := make(map[string]interface{})
+	for k, v := range m {
+		if _, ok := asStringMap(v); ok {
+			sections[k] = v
+		} else {
+			flatten(k, ".", v, globals)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	for _, key := range opts.sortedKeys(globals) {
+		if perr := checkININame("key", key); perr != nil {
+			return nil, perr
+		}
+		fmt.Fprintf(buf, "%s = %s\n", key, quoteINIValue(scalarString(globals[key]), opts.Quote))
+	}
+
+	for _, section := range opts.sortedKeys(sections) {
+		if perr := checkININame("section name", section); perr != nil {
+			return nil, perr
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(buf, "[%s]\n", section)
+
+		values := make(map[string]interface{})
+		flatten("", ".", sections[section], values)
+		for _, key := range opts.sortedKeys(values) {
+			if perr := checkININame("key", key); perr != nil {
+				return nil, perr
+			}
+			fmt.Fprintf(buf, "%s = %s\n", key, quoteINIValue(scalarString(values[key]), opts.Quote))
+		}
+	}
+
+	return pongo2.AsValue(buf.String()), nil
+}
+
+func writeXMLElement(buf *bytes.Buffer, opts serializerOptions, name string, v interface{}, indent string, depth int) *pongo2.Error {
+	if !reXMLName.MatchString(name) {
+		return &pongo2.Error{
+			Sender:    "filter:to_xml",
+			OrigError: FilterError{Reason: fmt.Sprintf("key is not a valid XML element name: %q", name)},
+		}
+	}
+
+	prefix := strings.Repeat(indent, depth)
+	newline := ""
+	if indent != "" {
+		newline = "\n"
+	}
+
+	if s, ok := asSlice(v); ok {
+		for _, elem := range s {
+			if perr := writeXMLElement(buf, opts, name, elem, indent, depth); perr != nil {
+				return perr
+			}
+		}
+		return nil
+	}
+
+	if m, ok := asStringMap(v); ok {
+		fmt.Fprintf(buf, "%s<%s>%s", prefix, name, newline)
+		for _, key := range opts.sortedKeys(m) {
+			if perr := writeXMLElement(buf, opts, key, m[key], indent, depth+1); perr != nil {
+				return perr
+			}
+		}
+		fmt.Fprintf(buf, "%s</%s>%s", prefix, name, newline)
+		return nil
+	}
+
+	text := scalarString(v)
+	switch opts.Quote {
+	case quoteAlways:
+		text = "<![CDATA[" + strings.ReplaceAll(text, "]]>", "]]]]><![CDATA[>") + "]]>"
+	case quoteNever:
+	default:
+		escaped := new(bytes.Buffer)
+		_ = xml.EscapeText(escaped, []byte(text))
+		text = escaped.String()
+	}
+	fmt.Fprintf(buf, "%s<%s>%s</%s>%s", prefix, name, text, name, newline)
+	return nil
+}
+
+// FilterToXML outputs structured data as XML. Map keys become elements and lists become
+// repeated elements. Keys which are not valid XML names are rejected. A list input is
+// wrapped in the root element with one "item=" element (default "item") per entry. The root
+// element is named with "root=" (default "root") and "indent=" sets the number of spaces to
+// indent by (default 2, 0 for a single line). quote=always wraps text in CDATA sections.
+func (fs *FilterSet) FilterToXML(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	opts, perr := parseSerializerOptions("filter:to_xml", param)
+	if perr != nil {
+		return nil, perr
+	}
+
+	indentWidth, err := strconv.Atoi(opts.flag("indent", "2"))
+	if err != nil {
+		return nil, &pongo2.Error{
+			Sender:    "filter:to_xml",
+			OrigError: FilterError{Reason: "indent option must be an integer."},
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if _, noHeader := opts.Flags["noheader"]; !noHeader {
+		buf.WriteString(xml.Header)
+	}
+	value := in.Interface()
+	if list, ok := asSlice(value); ok {
+		// A document has a single root element.
+		value = map[string]interface{}{opts.flag("item", "item"): list}
+	}
+	if perr := writeXMLElement(buf, opts, opts.flag("root", "root"), value, strings.Repeat(" ", indentWidth), 0); perr != nil {
+		return nil, perr
+	}
+
+	return pongo2.AsValue(strings.TrimSuffix(buf.String(), "\n")), nil
+}
+
+// quoteHCLString quotes a string using the escapes of the HCL native syntax. Template
+// sequences are escaped so values are never interpolated.
+func quoteHCLString(s string) string {
+	buf := new(strings.Builder)
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '\\':
+			buf.WriteString(`\\`)
+		case r == '"':
+			buf.WriteString(`\"`)
+		case r == '\n':
+			buf.WriteString(`\n`)
+		case r == '\r':
+			buf.WriteString(`\r`)
+		case r == '\t':
+			buf.WriteString(`\t`)
+		case !unicode.IsPrint(r) && r <= 0xFFFF:
+			fmt.Fprintf(buf, `\u%04X`, r)
+		case !unicode.IsPrint(r):
+			fmt.Fprintf(buf, `\U%08X`, r)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+	s = strings.ReplaceAll(buf.String(), "${", "$${")
+	return strings.ReplaceAll(s, "%{", "%%{")
+}
+
+func writeHCLValue(buf *bytes.Buffer, opts serializerOptions, v interface{}, depth int) {
+	const indent = "  "
+
+	if s, ok := asSlice(v); ok {
+		if len(s) == 0 {
+			buf.WriteString("[]")
+			return
+		}
+		buf.WriteString("[\n")
+		for _, elem := range s {
+			buf.WriteString(strings.Repeat(indent, depth+1))
+			writeHCLValue(buf, opts, elem, depth+1)
+			buf.WriteString(",\n")
+		}
+		buf.WriteString(strings.Repeat(indent, depth) + "]")
+		return
+	}
+
+	if m, ok := asStringMap(v); ok {
+		if len(m) == 0 {
+			buf.WriteString("{}")
+			return
+		}
+		buf.WriteString("{\n")
+		writeHCLAttributes(buf, opts, m, depth+1)
+		buf.WriteString(strings.Repeat(indent, depth) + "}")
+		return
+	}
+
+	switch value := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		buf.WriteString(scalarString(value))
+	default:
+		buf.WriteString(quoteHCLString(scalarString(value)))
+	}
+}
+
+func writeHCLAttributes(buf *bytes.Buffer, opts serializerOptions, m map[string]interface{}, depth int) {
+	const indent = "  "
+	for _, key := range opts.sortedKeys(m) {
+		name := key
+		quoteKey := opts.Quote == quoteAlways || (opts.Quote == quoteAuto && !reHCLIdentifier.MatchString(key))
+		if depth > 0 && quoteKey {
+			name = quoteHCLString(key)
+		}
+		buf.WriteString(strings.Repeat(indent, depth) + name + " = ")
+		writeHCLValue(buf, opts, m[key], depth)
+		buf.WriteString("\n")
+	}
+}
+
+// FilterToHCL outputs a map as HCL attributes. Nested maps become objects and lists become
+// tuples. Top-level keys must be valid identifiers. Object keys which are not valid
+// identifiers are quoted unless quote=never; quote=always quotes every object key.
+func (fs *FilterSet) FilterToHCL(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	opts, perr := parseSerializerOptions("filter:to_hcl", param)
+	if perr != nil {
+		return nil, perr
+	}
+	m, perr := serializerInputMap("filter:to_hcl", in)
+	if perr != nil {
+		return nil, perr
+	}
+
+	for key := range m {
+		if !reHCLIdentifier.MatchString(key) {
+			return nil, &pongo2.Error{
+				Sender:    "filter:to_hcl",
+				OrigError: FilterError{Reason: fmt.Sprintf("top-level key is not a valid HCL identifier: %s", key)},
+			}
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	writeHCLAttributes(buf, opts, m, 0)
+	return pongo2.AsValue(buf.String()), nil
+}
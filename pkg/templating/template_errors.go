@@ -0,0 +1,127 @@
+package templating
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/pkg/errors"
+)
+
+// snippetContext is the number of source lines shown before the offending line.
+const snippetContext = 1
+
+// TemplateError is a template parse or execution error located in the template source.
+// Line, Column and Token are zero values when the error carries no position.
+type TemplateError struct {
+	Filename string
+	Line     int
+	Column   int
+	Token    string
+	// Sender is the pongo2 component which raised the error, e.g. "parser" or "filter:date".
+	Sender string
+	Err    error
+	source []string
+}
+
+// newTemplateError locates err in the template at templatePath with the given source. Errors
+// raised inside an included template are located in that template when it can be read.
+func newTemplateError(templatePath string, source []byte, err error) *TemplateError {
+	result := &TemplateError{Filename: templatePath, Err: err, source: splitLines(source)}
+
+	var perr *pongo2.Error
+	if !errors.As(err, &perr) {
+		return result
+	}
+	// Descend to the innermost pongo2 error, which is the most precise.
+	for {
+		var inner *pongo2.Error
+		if !errors.As(perr.OrigError, &inner) {
+			break
+		}
+		perr = inner
+	}
+
+	result.Line = perr.Line
+	result.Column = perr.Column
+	result.Sender = perr.Sender
+	if perr.OrigError != nil {
+		result.Err = perr.OrigError
+	}
+	if perr.Token != nil {
+		result.Token = perr.Token.Val
+	}
+	if perr.Filename != "" && perr.Filename != "<string>" {
+		included := perr.Filename
+		if !filepath.IsAbs(included) {
+			included = filepath.Join(filepath.Dir(templatePath), included)
+		}
+		if includedSource, err := os.ReadFile(included); err == nil {
+			result.Filename = included
+			result.source = splitLines(includedSource)
+		}
+	}
+	return result
+}
+
+func splitLines(source []byte) []string {
+	return strings.Split(strings.ReplaceAll(string(source), "\r\n", "\n"), "\n")
+}
+
+// Reason is the error message without its location.
+func (e *TemplateError) Reason() string {
+	reason := e.Err.Error()
+	if e.Sender != "" {
+		reason = e.Sender + ": " + reason
+	}
+	return reason
+}
+
+func (e *TemplateError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.Filename, e.Reason())
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.Filename, e.Line, e.Column, e.Reason())
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// Snippet renders the error compiler-style: the location and reason, followed by the
+// offending source line with a caret under the token.
+func (e *TemplateError) Snippet() string {
+	var sb strings.Builder
+	if e.Line == 0 {
+		_, _ = fmt.Fprintf(&sb, "%s: error: %s\n", e.Filename, e.Reason())
+		return sb.String()
+	}
+	_, _ = fmt.Fprintf(&sb, "%s:%d:%d: error: %s\n", e.Filename, e.Line, e.Column, e.Reason())
+	if e.Line > len(e.source) {
+		return sb.String()
+	}
+
+	gutter := len(fmt.Sprint(e.Line))
+	for lineNo := max(1, e.Line-snippetContext); lineNo <= e.Line; lineNo++ {
+		_, _ = fmt.Fprintf(&sb, " %*d | %s\n", gutter, lineNo, e.source[lineNo-1])
+	}
+
+	// Pad with the line's own tabs so the caret lines up however tabs are displayed.
+	line := []rune(e.source[e.Line-1])
+	column := min(max(e.Column, 1), len(line)+1)
+	padding := make([]rune, 0, column-1)
+	for _, r := range line[:column-1] {
+		if r == '\t' {
+			padding = append(padding, '\t')
+		} else {
+			padding = append(padding, ' ')
+		}
+	}
+	width := utf8.RuneCountInString(strings.SplitN(e.Token, "\n", 2)[0]) //nolint:mnd
+	width = max(1, min(width, len(line)-(column-1)))
+	_, _ = fmt.Fprintf(&sb, " %*s | %s^%s\n", gutter, "", string(padding), strings.Repeat("~", width-1))
+	return sb.String()
+}
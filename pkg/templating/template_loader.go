@@ -4,36 +4,38 @@ import (
 	"os"
 
 	"github.com/flosch/pongo2/v6"
-	"go.uber.org/zap"
+	"github.com/pkg/errors"
 )
 
 type LoadedTemplate struct {
 	Template    *pongo2.Template
 	TemplateSet *pongo2.TemplateSet
+	// Path and Source locate execution errors in the template.
+	Path   string
+	Source []byte
 }
 
-func LoadTemplate(templatePath string) *LoadedTemplate {
-	logger := zap.L()
+// LoadTemplate reads and parses the template at templatePath. Parse errors are returned as
+// *TemplateError.
+func LoadTemplate(templatePath string) (*LoadedTemplate, error) {
 	// Load template
 	templateBytes, err := os.ReadFile(templatePath)
 	if err != nil {
-		logger.Error("Could not read template file", zap.Error(err))
-		return nil
+		return nil, errors.Wrap(err, "could not read template file")
 	}
 
-	templateString := string(templateBytes)
-
 	templateSet := pongo2.NewSet(templatePath, pongo2.DefaultLoader)
 
 	// Load the template to parse it and get it into the cache.
-	tmpl, err := templateSet.FromString(templateString)
+	tmpl, err := templateSet.FromBytes(templateBytes)
 	if err != nil {
-		logger.Error("Could not template file", zap.Error(err), zap.String("template", templatePath))
-		return nil
+		return nil, newTemplateError(templatePath, templateBytes, err)
 	}
 
 	return &LoadedTemplate{
 		Template:    tmpl,
 		TemplateSet: templateSet,
-	}
+		Path:        templatePath,
+		Source:      templateBytes,
+	}, nil
 }
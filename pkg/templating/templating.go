@@ -11,9 +11,11 @@ import (
 	"os/user"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/flosch/pongo2/v6"
 	"github.com/pelletier/go-toml"
+	"github.com/wrouesnel/p2cli/pkg/redact"
 	"gopkg.in/yaml.v2"
 )
 
@@ -36,6 +38,12 @@ type FilterSet struct {
 	OutputFileName string
 	Chown          func(name string, uid, gid int) error
 	Chmod          func(name string, mode os.FileMode) error
+	// AllowRandom permits generator filters to produce non-deterministic output when no seed is given.
+	AllowRandom bool
+	// Now returns the current time for the date filters. time.Now is used if unset.
+	Now func() time.Time
+	// Redactor records values passed to the secret filter. The filter is a no-op if unset.
+	Redactor *redact.Redactor
 }
 
 func (fs *FilterSet) FilterSetOwner(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
@@ -111,7 +119,7 @@ func (fs *FilterSet) FilterSetGroup(in *pongo2.Value, param *pongo2.Value) (*pon
 		}
 	}
 
-	if err := os.Chown(fs.OutputFileName, -1, gid); err != nil {
+	if err := fs.Chown(fs.OutputFileName, -1, gid); err != nil {
 		return nil, &pongo2.Error{
 			Sender:    "filter:SetGroup",
 			OrigError: err,